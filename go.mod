@@ -1,3 +1,5 @@
 module github.com/echa/go-csv
 
-go 1.17
+go 1.18
+
+require golang.org/x/text v0.3.8
@@ -20,10 +20,17 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
 )
 
 // Marshaler is the interface implemented by types that can marshal themselves
@@ -33,6 +40,57 @@ type Marshaler interface {
 	MarshalCSV() ([]string, error)
 }
 
+// HeaderMarshaler is the interface implemented by Marshaler types that can be
+// inlined into a parent record with the `inline` flag. MarshalCSVHeader returns
+// the header fields matching the values returned by MarshalCSV and must not
+// depend on the value it is called on.
+type HeaderMarshaler interface {
+	MarshalCSVHeader() []string
+}
+
+// HeaderStyle defines how the Encoder formats header field names that are
+// derived from Go struct field names. Names taken from struct tags are always
+// written verbatim.
+type HeaderStyle int
+
+const (
+	AsIs      HeaderStyle = iota // FirstName
+	SnakeCase                    // first_name
+	KebabCase                    // first-name
+	LowerCase                    // firstname
+)
+
+// EncodeError describes a failure to encode a single record.
+type EncodeError struct {
+	index  int
+	reason error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("csv: record %d: %v", e.index, e.reason)
+}
+
+// Index returns the position of the failed record in the encoded slice.
+func (e *EncodeError) Index() int {
+	return e.index
+}
+
+// Unwrap returns the underlying error.
+func (e *EncodeError) Unwrap() error {
+	return e.reason
+}
+
+// MultiError is a list of errors collected while encoding.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	s := make([]string, len(m))
+	for i, err := range m {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
 // Encoder writes CSV header and CSV records to an output stream. The encoder
 // may be configured to omit the header, to use a user-defined separator and
 // to trim string values before writing them as CSV fields.
@@ -42,6 +100,39 @@ type Encoder struct {
 	trim        bool
 	writeHeader bool
 	headerKeys  []string
+	headerStyle HeaderStyle
+	flushEvery  int
+	numRecords  int
+	pad         bool
+	emitZero    bool
+	skipNil     bool
+	continueErr bool
+	werr        error
+	trailSep    bool
+	intGroup    rune
+	footer      func(int, []byte) string
+	written     []byte
+	keepOut     bool
+	closed      bool
+	printer     *message.Printer
+	localeFmt   localeFormat
+	styled      map[reflect.Type][]string
+	crlfQuote   bool
+	unionHead   bool
+	quoteHead   bool
+	nullVal     string
+	subHeader   map[string]string
+	stringer    bool
+	colFilter   func(field string, record interface{}) bool
+	rowHead     string
+	rowNo       int
+	escapes     bool
+	tag         string
+	quoteFn     func(field, value string) bool
+	timeFmt     string
+	prefixFn    func(record interface{}) string
+	suffixFn    func(record interface{}) string
+	record      interface{}
 }
 
 // NewEncoder returns a new encoder that writes to w.
@@ -51,9 +142,148 @@ func NewEncoder(w io.Writer) *Encoder {
 		sep:         string(Separator),
 		trim:        true,
 		writeHeader: true,
+		emitZero:    true,
+		stringer:    true,
+		tag:         tagName,
 	}
 }
 
+// NewTSVEncoder returns a new encoder for tab separated values that writes to
+// w. Special characters inside fields are escaped with a backslash and field
+// values are not trimmed.
+func NewTSVEncoder(w io.Writer) *Encoder {
+	e := NewEncoder(w).Separator('\t').BackslashEscapes(true)
+	e.trim = false
+	return e
+}
+
+// UnionHeader controls if Encode derives the header from all slice elements
+// instead of only the first one. When enabled, the header contains the union of
+// fields of all element types in order of first appearance and each record
+// leaves fields its type does not define empty. This is useful for slices of
+// interfaces holding different struct types. Slices of string keyed maps always
+// use the sorted union of map keys unless a header was set with EncodeHeader.
+func (e *Encoder) UnionHeader(t bool) *Encoder {
+	e.unionHead = t
+	return e
+}
+
+// NullValue sets the string s written for nil pointers, nil interfaces and nil
+// byte slices in struct fields, map records and slice records. This allows to
+// distinguish nil from empty values such as zero times behind a non-nil
+// pointer or empty byte slices, which are written as empty fields. The default
+// is an empty string.
+func (e *Encoder) NullValue(s string) *Encoder {
+	e.nullVal = s
+	return e
+}
+
+// SecondaryHeader sets a second header line that is written right after the
+// header, for example to describe units of measurement. m maps header field
+// names to their secondary header value. Fields without entry remain empty.
+func (e *Encoder) SecondaryHeader(m map[string]string) *Encoder {
+	e.subHeader = m
+	return e
+}
+
+// UseStringer controls if the Encoder writes values of types implementing
+// fmt.Stringer by calling their String method. This is the default. When
+// disabled, the underlying value of such types is written instead, which is
+// useful when String returns a representation not suited for CSV.
+func (e *Encoder) UseStringer(t bool) *Encoder {
+	e.stringer = t
+	return e
+}
+
+// BackslashEscapes controls if the Encoder escapes special characters inside
+// fields with a backslash instead of quoting fields. Tabs, newlines, carriage
+// returns, backslashes, quotes and the separator are escaped as used by tab
+// separated values.
+func (e *Encoder) BackslashEscapes(t bool) *Encoder {
+	e.escapes = t
+	return e
+}
+
+// TagName sets the name of the struct tag used to derive CSV header fields
+// from struct fields. The default is "csv". Other tags using the same syntax,
+// such as "json", may be used to avoid duplicating tags.
+func (e *Encoder) TagName(name string) *Encoder {
+	e.tag = name
+	e.styled = nil
+	return e
+}
+
+// WithRowNumber makes the Encoder prepend a column with the 1-based record
+// number to every record. header is written as name of this column in the
+// header line.
+func (e *Encoder) WithRowNumber(header string) *Encoder {
+	e.rowHead = header
+	return e
+}
+
+// ColumnFilter sets a predicate that decides per record whether the value of
+// a header field is written. When fn returns false, the field is left empty
+// for this record, for example to blank sensitive columns. The header is not
+// affected. fn is called with the header field name and the record value.
+func (e *Encoder) ColumnFilter(fn func(field string, record interface{}) bool) *Encoder {
+	e.colFilter = fn
+	return e
+}
+
+// TimeFormats sets the layouts for time.Time fields that don't define a layout
+// with "format=" in their struct tag. Only the first layout is used for
+// encoding, which allows to share a list of layouts with Decoder.TimeFormats.
+// By default times are written in RFC 3339 format with nanoseconds.
+func (e *Encoder) TimeFormats(layouts ...string) *Encoder {
+	e.timeFmt = ""
+	if len(layouts) > 0 {
+		e.timeFmt = layouts[0]
+	}
+	return e
+}
+
+// LinePrefix sets a function fn that returns text written before each record
+// line, such as a timestamp. fn is called with the record passed to
+// EncodeRecord or the row passed to EncodeRows. The text is written verbatim
+// outside of CSV fields, so decoders only skip it when it forms a comment line
+// of its own, like "#seq=1\n".
+func (e *Encoder) LinePrefix(fn func(record interface{}) string) *Encoder {
+	e.prefixFn = fn
+	return e
+}
+
+// LineSuffix sets a function fn that returns text written after each record
+// line and before the newline. See LinePrefix for details.
+func (e *Encoder) LineSuffix(fn func(record interface{}) string) *Encoder {
+	e.suffixFn = fn
+	return e
+}
+
+// QuoteFunc sets a function fn that decides whether a record field is quoted.
+// fn is called with the header field name and the value of each field. When
+// fn returns true the field is always quoted, otherwise the default quoting
+// rules apply, so values containing the separator or quotes stay valid CSV.
+// Header fields are not affected.
+func (e *Encoder) QuoteFunc(fn func(field, value string) bool) *Encoder {
+	e.quoteFn = fn
+	return e
+}
+
+// QuoteHeader controls if the Encoder quotes all header fields regardless of
+// their content. Record fields are quoted only when required.
+func (e *Encoder) QuoteHeader(t bool) *Encoder {
+	e.quoteHead = t
+	return e
+}
+
+// QuotedCRLF controls if the Encoder writes newlines inside quoted fields as
+// CRLF like Excel does. Fields containing newlines are always quoted and
+// written verbatim otherwise. A Decoder reads both forms back as '\n'.
+func (e *Encoder) QuotedCRLF(b bool) *Encoder {
+	e.crlfQuote = b
+	return e
+}
+
 // Header controls if the encoder will write a CSV header to the first line of
 // the output stream.
 func (e *Encoder) Header(h bool) *Encoder {
@@ -75,18 +305,180 @@ func (e *Encoder) Trim(t bool) *Encoder {
 	return e
 }
 
+// HeaderStyle sets the style used to format header field names for struct
+// fields without a csv struct tag.
+func (e *Encoder) HeaderStyle(s HeaderStyle) *Encoder {
+	e.headerStyle = s
+	e.styled = nil
+	return e
+}
+
+// FlushEvery controls how often the encoder flushes its underlying writer. When
+// n is positive and the writer implements a Flush method like http.Flusher or
+// bufio.Writer, Flush is called after every n records written.
+func (e *Encoder) FlushEvery(n int) *Encoder {
+	e.flushEvery = n
+	return e
+}
+
+// EmitZero controls if the encoder writes zero values like 0, false or the zero
+// time. When false, zero values of all fields are written as empty fields. Fields
+// tagged with `omitempty` are always written empty when zero.
+func (e *Encoder) EmitZero(t bool) *Encoder {
+	e.emitZero = t
+	return e
+}
+
+// SkipNil controls if the encoder skips nil elements. By default, nil elements
+// are written as records with all fields empty.
+func (e *Encoder) SkipNil(t bool) *Encoder {
+	e.skipNil = t
+	return e
+}
+
+// ContinueOnError controls if Encode continues with the next record when
+// a record fails to encode. Failed records are skipped and their errors are
+// returned as MultiError of EncodeError values after all records have been
+// processed. Errors from the underlying writer always stop encoding.
+func (e *Encoder) ContinueOnError(t bool) *Encoder {
+	e.continueErr = t
+	return e
+}
+
+// TrailingSeparator controls if the encoder terminates each line with a field
+// separator as expected by some legacy consumers. Use Decoder.TrimTrailingSeparator
+// to read such files.
+func (e *Encoder) TrailingSeparator(t bool) *Encoder {
+	e.trailSep = t
+	return e
+}
+
+// IntGrouping sets a rune r that is inserted between groups of thousands when
+// writing integer values, e.g. 1,000,000. Fields are quoted when r equals the
+// separator. Grouping is disabled when r is zero, which is the default.
+func (e *Encoder) IntGrouping(r rune) *Encoder {
+	e.intGroup = r
+	return e
+}
+
+// Footer sets a function fn that produces a final line written by Close after
+// all records, for example a record count or a checksum. fn is called with the
+// number of records and, when KeepOutput is enabled, all bytes written before.
+// Otherwise written is nil. The footer line is written verbatim. Use a line
+// starting with a comment character to keep the output readable by a Decoder.
+//
+// To checksum large outputs without keeping them in memory, wrap the
+// Encoder's writer with io.MultiWriter and a hash.Hash and read the sum in fn.
+func (e *Encoder) Footer(fn func(count int, written []byte) string) *Encoder {
+	e.footer = fn
+	return e
+}
+
+// KeepOutput controls if the encoder keeps a copy of all lines it writes to
+// pass them to the Footer function. The copy grows with the output, so only
+// enable it for small outputs. Default is false.
+func (e *Encoder) KeepOutput(t bool) *Encoder {
+	e.keepOut = t
+	return e
+}
+
+// Locale sets a language tag used to format numbers, times and booleans, e.g.
+// 1.234,5, 17.05.2020 08:30:00 and wahr for German. Floats are never written
+// in scientific notation. Localized numbers take precedence over IntGrouping.
+// Use a separator different from the locale's decimal and grouping separators
+// or numbers will be quoted.
+//
+// Times use the layout of the locale unless TimeFormats or a format= tag
+// define one. Time layouts and boolean words are available for de, en, en-US,
+// es, fr, it, nl, pl, pt, ru and sv. Other languages keep RFC 3339 times and
+// true/false. Localized output is meant for reports and cannot be read back
+// by a Decoder.
+func (e *Encoder) Locale(tag language.Tag) *Encoder {
+	e.printer = message.NewPrinter(tag)
+	e.localeFmt = lookupLocale(tag)
+	return e
+}
+
+// localeFormat defines how times and booleans are written for a language.
+type localeFormat struct {
+	time    string
+	yes, no string
+}
+
+// localeFormats maps language tags to their time layouts and boolean words.
+var localeFormats = map[string]localeFormat{
+	"de":    {"02.01.2006 15:04:05", "wahr", "falsch"},
+	"en":    {"02/01/2006 15:04:05", "true", "false"},
+	"en-US": {"01/02/2006 3:04:05 PM", "true", "false"},
+	"es":    {"02/01/2006 15:04:05", "verdadero", "falso"},
+	"fr":    {"02/01/2006 15:04:05", "vrai", "faux"},
+	"it":    {"02/01/2006 15:04:05", "vero", "falso"},
+	"nl":    {"02-01-2006 15:04:05", "waar", "onwaar"},
+	"pl":    {"02.01.2006 15:04:05", "prawda", "fałsz"},
+	"pt":    {"02/01/2006 15:04:05", "verdadeiro", "falso"},
+	"ru":    {"02.01.2006 15:04:05", "истина", "ложь"},
+	"sv":    {"2006-01-02 15:04:05", "sant", "falskt"},
+}
+
+// lookupLocale returns the formats for tag, falling back to the formats of
+// its base language and zero formats for unsupported languages.
+func lookupLocale(tag language.Tag) localeFormat {
+	base, _ := tag.Base()
+	if region, conf := tag.Region(); conf == language.Exact {
+		if f, ok := localeFormats[base.String()+"-"+region.String()]; ok {
+			return f
+		}
+	}
+	return localeFormats[base.String()]
+}
+
+// timeLayout returns the layout for times without a format= tag.
+func (e *Encoder) timeLayout() string {
+	if e.timeFmt != "" {
+		return e.timeFmt
+	}
+	return e.localeFmt.time
+}
+
+// PadRecords controls if the encoder pads records returned by a Marshaler with
+// empty fields when they are shorter than the header.
+func (e *Encoder) PadRecords(t bool) *Encoder {
+	e.pad = t
+	return e
+}
+
 // HeaderWritten returns true if the CSV header has already been written
 // to the output.
 func (e *Encoder) HeaderWritten() bool {
 	return len(e.headerKeys) > 0
 }
 
+// Close finishes encoding by writing the optional footer line and flushing
+// the underlying writer if supported. Close does not close the underlying
+// writer.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.footer != nil {
+		line := e.footer(e.numRecords, e.written)
+		e.written = nil
+		if _, err := e.w.Write([]byte(line + "\n")); err != nil {
+			e.werr = fmt.Errorf("csv: %v", err)
+			return e.werr
+		}
+	}
+	return e.flush()
+}
+
 // Allow using the encoder as io.Writer
 func (e *Encoder) Write(p []byte) (n int, err error) {
 	return e.w.Write(p)
 }
 
-// Marshal returns the CSV encoding of slice v.
+// Marshal returns the CSV encoding of slice v. A single struct or pointer
+// to a struct is encoded like a slice with one element.
 //
 // When the slice's element type implements the Marshaler interface, MarshalCSV
 // is called for each element and the resulting string slice is written in the
@@ -102,6 +494,37 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 //     // Field is ignored by this package.
 //     Field int `csv:"-"`
 //
+//     // Field is formatted using the time layout following "format=".
+//     Field time.Time `csv:"name,format=2006-01-02"`
+//
+//     // Slice elements are joined by the separator following "split=".
+//     Field []int `csv:"name,split=;"`
+//
+//     // Field is written as empty CSV field when it's zero.
+//     Field int `csv:"name,omitempty"`
+//
+//     // Field is written as second column, fields without order follow
+//     // all ordered fields.
+//     Field int `csv:"name,order=2"`
+//
+//     // Fields of the embedded struct are named "home.<name>". Without
+//     // a tag name the embedded type name is used as prefix.
+//     Address `csv:"home,prefix"`
+//
+//     // Field implements Marshaler and HeaderMarshaler and its values are
+//     // written as separate CSV fields named by MarshalCSVHeader.
+//     Field Sub `csv:"sub,inline"`
+//
+//     // Map entries are written as additional CSV fields following all
+//     // struct fields. Keys of the first record determine the header,
+//     // later records with keys missing from the header fail to encode.
+//     Field map[string]string `csv:",any"`
+//
+// Types implementing fmt.Stringer are written using their String method with
+// the exception of types based on string. Such types are always written using
+// their underlying string value, so decoding the output yields the original
+// value.
+//
 // Marshal only supports strings, integers, floats, booleans, []byte slices
 // and [N]byte arrays as well as pointers to these types. Slices of other
 // types, maps, interfaces and channels are not supported and result in an
@@ -114,6 +537,93 @@ func Marshal(v interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// EncodeReader returns a reader that produces the CSV encoding of slice v
+// lazily while it is read. Encoding runs in a separate goroutine that blocks
+// until the reader consumes its output, so the encoding is never buffered as
+// a whole. Options in opts are applied to the Encoder before encoding starts.
+// Errors during encoding are returned by the reader's Read method. Callers that
+// stop reading before EOF must close the reader to stop the encoding goroutine.
+func EncodeReader(v interface{}, opts ...func(*Encoder)) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := NewEncoder(pw)
+		for _, opt := range opts {
+			opt(enc)
+		}
+		err := enc.Encode(v)
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// MarshalMap returns a two-line CSV encoding of map m. The first line contains
+// the sorted map keys as header and the second line contains the corresponding
+// map values as a single record. m must be a map with string keys and values
+// of a type supported by Marshal. Values are formatted like struct fields and
+// options in opts are applied to the Encoder before encoding.
+func MarshalMap(m interface{}, opts ...func(*Encoder)) ([]byte, error) {
+	val := reflect.Indirect(reflect.ValueOf(m))
+	if !val.IsValid() {
+		return nil, nil
+	}
+	if val.Kind() != reflect.Map || val.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("csv: non-map type passed to MarshalMap: %s %s", val.Kind().String(), val.Type().String())
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	for _, opt := range opts {
+		opt(enc)
+	}
+
+	// sort keys for a stable output
+	keys := mapKeys(val)
+
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		f := val.MapIndex(reflect.ValueOf(k).Convert(val.Type().Key()))
+		if f.Kind() == reflect.Interface || f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				values[i] = enc.nullVal
+				continue
+			}
+			f = f.Elem()
+		}
+		s, err := enc.marshalValue(f)
+		if err != nil {
+			return nil, fmt.Errorf("csv: %v", err)
+		}
+		values[i] = s
+	}
+
+	if err := enc.output(keys); err != nil {
+		return nil, err
+	}
+	if err := enc.output(values); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// isMapSlice returns true when the elements of slice val are string keyed maps.
+func isMapSlice(val reflect.Value) bool {
+	typ := indirectType(val.Type().Elem())
+	return typ.Kind() == reflect.Map && typ.Key().Kind() == reflect.String
+}
+
+// mapKeys returns the sorted keys of string keyed map val.
+func mapKeys(val reflect.Value) []string {
+	keys := make([]string, 0, val.Len())
+	for _, k := range val.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Encode writes the CSV encoding of slice v to the stream.
 //
 // See the documentation for Marshal for details about the conversion of Go values
@@ -124,20 +634,67 @@ func (e *Encoder) Encode(v interface{}) error {
 		return nil
 	}
 
+	// treat a single struct like a slice with one element
+	if val.Kind() == reflect.Struct {
+		val = reflect.Append(reflect.MakeSlice(reflect.SliceOf(val.Type()), 0, 1), val)
+	}
+
 	if val.Kind() != reflect.Slice {
 		return fmt.Errorf("csv: non-slice type passed to Marshal: %s %s", val.Kind().String(), val.Type().String())
 	}
 
+	if val.Len() == 0 {
+		return nil
+	}
+
 	// always prepare header, but write only when requested
-	if err := e.EncodeHeader(nil, val.Index(0).Interface()); err != nil {
+	var fields []string
+	if (e.unionHead || isMapSlice(val)) && len(e.headerKeys) == 0 {
+		keys, err := e.unionHeader(val)
+		if err != nil {
+			return err
+		}
+		if isMapSlice(val) {
+			sort.Strings(keys)
+		}
+		fields = keys
+	}
+	if err := e.EncodeHeader(fields, firstNonNil(val)); err != nil {
 		return err
 	}
 
 	// process records
+	var errs MultiError
 	for i, l := 0, val.Len(); i < l; i++ {
 		if err := e.EncodeRecord(val.Index(i).Interface()); err != nil {
+			if !e.continueErr || e.werr != nil {
+				return err
+			}
+			errs = append(errs, &EncodeError{i, err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// EncodeRows writes each row as a CSV record with fields in the given order.
+// No header is derived for rows. To write a header, call EncodeHeader with an
+// explicit list of fields first.
+func (e *Encoder) EncodeRows(rows [][]string) error {
+	defer func() { e.record = nil }()
+	for _, row := range rows {
+		e.record = row
+		if err := e.output(e.padFields(row)); err != nil {
 			return err
 		}
+		e.numRecords++
+		if e.flushEvery > 0 && e.numRecords%e.flushEvery == 0 {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -150,13 +707,27 @@ func (e *Encoder) Encode(v interface{}) error {
 // type of records and their field names. v in this case is an element of the
 // slice you would pass to Marshal, not a slice itself.
 func (e *Encoder) EncodeHeader(fields []string, v interface{}) error {
+	// prepare and write only once
+	if len(e.headerKeys) > 0 {
+		return nil
+	}
 	if err := e.buildHeader(fields, reflect.ValueOf(v)); err != nil {
 		return err
 	}
 	if !e.writeHeader {
 		return nil
 	}
-	return e.output(e.headerKeys)
+	if err := e.writeLine(e.withRowColumn(e.headerKeys, e.rowHead), e.quoteHead, nil); err != nil {
+		return err
+	}
+	if e.subHeader == nil {
+		return nil
+	}
+	sub := make([]string, len(e.headerKeys))
+	for i, key := range e.headerKeys {
+		sub[i] = e.subHeader[key]
+	}
+	return e.writeLine(e.withRowColumn(sub, ""), e.quoteHead, nil)
 }
 
 // EncodeRecord writes the CSV encoding of v to the output stream.
@@ -166,9 +737,59 @@ func (e *Encoder) EncodeRecord(v interface{}) error {
 			return err
 		}
 	}
-	if err := e.marshal(reflect.ValueOf(v)); err != nil {
+	e.record = v
+	defer func() { e.record = nil }()
+	val := reflect.ValueOf(v)
+	if isNil(val) {
+		// skip or write an empty record for nil values
+		if e.skipNil {
+			return nil
+		}
+		if err := e.output(make([]string, len(e.headerKeys))); err != nil {
+			return err
+		}
+	} else if err := e.marshal(val); err != nil {
 		return fmt.Errorf("csv: %v", err)
 	}
+	e.numRecords++
+	if e.flushEvery > 0 && e.numRecords%e.flushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// isNil returns true when val is invalid or a nil pointer or interface.
+func isNil(val reflect.Value) bool {
+	switch val.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface:
+		return val.IsNil()
+	}
+	return false
+}
+
+// firstNonNil returns the first non-nil element of slice val or the first
+// element when all elements are nil.
+func firstNonNil(val reflect.Value) interface{} {
+	for i, l := 0, val.Len(); i < l; i++ {
+		if v := val.Index(i).Interface(); !isNil(reflect.ValueOf(v)) {
+			return v
+		}
+	}
+	return val.Index(0).Interface()
+}
+
+// flush flushes the underlying writer when it supports flushing.
+func (e *Encoder) flush() error {
+	switch w := e.w.(type) {
+	case interface{ Flush() error }:
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("csv: %v", err)
+		}
+	case interface{ Flush() }:
+		w.Flush()
+	}
 	return nil
 }
 
@@ -182,37 +803,369 @@ func (e *Encoder) buildHeader(fields []string, val reflect.Value) error {
 		e.headerKeys = fields
 		return nil
 	}
-	if val.Kind() == reflect.Ptr && !val.IsNil() {
-		val = val.Elem()
+	if !val.IsValid() {
+		return fmt.Errorf("csv: cannot derive header from nil value")
 	}
-	tinfo, err := getTypeInfo(val.Type())
+	if v := reflect.Indirect(val); v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+		e.headerKeys = mapKeys(v)
+		return nil
+	}
+	keys, err := e.typeHeader(val.Type())
 	if err != nil {
-		return fmt.Errorf("csv: %v", err)
+		return err
 	}
-	e.headerKeys = make([]string, len(tinfo.fields))
-	for i, finfo := range tinfo.fields {
-		e.headerKeys[i] = finfo.name
+	// append keys of an `any` map in sorted order
+	if m := anyMap(reflect.Indirect(val), e.tag); m.IsValid() {
+		seen := make(map[string]bool)
+		for _, k := range keys {
+			seen[k] = true
+		}
+		for _, k := range mapKeys(m) {
+			if !seen[k] {
+				keys = append(keys, k)
+			}
+		}
 	}
+	e.headerKeys = keys
 	return nil
 }
 
+// anyMap returns the string keyed map of struct val tagged with `any` in
+// struct tag tag or an invalid value when there is no such map.
+func anyMap(val reflect.Value, tag string) reflect.Value {
+	if val.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	tinfo, err := getTypeInfo(val.Type(), tag)
+	if err != nil {
+		return reflect.Value{}
+	}
+	for _, finfo := range tinfo.fields {
+		if finfo.flags&fAny == 0 {
+			continue
+		}
+		if f := finfo.lookup(val); f.Kind() == reflect.Map && f.Type().Key().Kind() == reflect.String {
+			return f
+		}
+	}
+	return reflect.Value{}
+}
+
+// checkAnyKeys fails when the `any` map of struct val contains keys that are
+// missing from the header and would be dropped otherwise.
+func (e *Encoder) checkAnyKeys(val reflect.Value) error {
+	m := anyMap(val, e.tag)
+	if !m.IsValid() || m.Len() == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(e.headerKeys))
+	for _, k := range e.headerKeys {
+		seen[k] = true
+	}
+	for _, k := range mapKeys(m) {
+		if !seen[k] {
+			return fmt.Errorf("key %q of any map is not in header", k)
+		}
+	}
+	return nil
+}
+
+// typeHeader returns the CSV header fields for type typ.
+func (e *Encoder) typeHeader(typ reflect.Type) ([]string, error) {
+	tinfo, err := getTypeInfo(indirectType(typ), e.tag)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %v", err)
+	}
+	keys := make([]string, 0, len(tinfo.fields))
+	for _, finfo := range tinfo.orderedFields() {
+		// skip line number and raw record fields
+		if finfo.flags&(fLine|fRecord) > 0 {
+			continue
+		}
+		ftyp := indirectType(typ).FieldByIndex(finfo.idx).Type
+		// `any` maps contribute their keys instead
+		if finfo.flags&fAny > 0 && ftyp.Kind() == reflect.Map {
+			continue
+		}
+		// inlined fields contribute their own header
+		if finfo.flags&fInline > 0 {
+			head, ok := inlineHeader(ftyp)
+			if !ok {
+				return nil, fmt.Errorf("csv: inline field %s of type %s must implement HeaderMarshaler", finfo.name, ftyp)
+			}
+			keys = append(keys, head...)
+			continue
+		}
+		keys = append(keys, e.headerName(&finfo))
+	}
+	return keys, nil
+}
+
+// inlineHeader returns the header fields of type typ implementing
+// HeaderMarshaler. The second result is false when typ does not implement
+// HeaderMarshaler.
+func inlineHeader(typ reflect.Type) ([]string, bool) {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if h, ok := reflect.New(typ).Interface().(HeaderMarshaler); ok {
+		return h.MarshalCSVHeader(), true
+	}
+	return nil, false
+}
+
+// inlineValues returns the values of all inlined fields of struct val mapped
+// by their header field names. Fields of nil inlined pointers are written as
+// null value.
+func (e *Encoder) inlineValues(val reflect.Value) (map[string]string, error) {
+	tinfo, err := getTypeInfo(val.Type(), e.tag)
+	if err != nil {
+		return nil, nil
+	}
+	var values map[string]string
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fInline == 0 {
+			continue
+		}
+		ftyp := val.Type().FieldByIndex(finfo.idx).Type
+		head, ok := inlineHeader(ftyp)
+		if !ok {
+			return nil, fmt.Errorf("inline field %s of type %s must implement HeaderMarshaler", finfo.name, ftyp)
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		fv := finfo.lookup(val)
+		if !fv.IsValid() || fv.Kind() == reflect.Ptr && fv.IsNil() {
+			for _, key := range head {
+				values[key] = e.nullVal
+			}
+			continue
+		}
+		if fv.Kind() != reflect.Ptr {
+			// use an addressable copy for pointer receivers
+			pv := reflect.New(fv.Type())
+			pv.Elem().Set(fv)
+			fv = pv
+		}
+		m, ok := fv.Interface().(Marshaler)
+		if !ok {
+			return nil, fmt.Errorf("inline field %s of type %s must implement Marshaler", finfo.name, fv.Type())
+		}
+		fields, err := m.MarshalCSV()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != len(head) {
+			return nil, fmt.Errorf("inline field %s has %d values for %d header fields", finfo.name, len(fields), len(head))
+		}
+		for j, key := range head {
+			values[key] = fields[j]
+		}
+	}
+	return values, nil
+}
+
+// unionHeader returns the union of header fields of all non-nil elements in
+// slice val in order of first appearance.
+func (e *Encoder) unionHeader(val reflect.Value) ([]string, error) {
+	var (
+		keys  []string
+		seen  = make(map[string]bool)
+		types = make(map[reflect.Type]bool)
+	)
+	for i, l := 0, val.Len(); i < l; i++ {
+		v := reflect.ValueOf(val.Index(i).Interface())
+		if isNil(v) || types[v.Type()] {
+			continue
+		}
+		var fields []string
+		if m := reflect.Indirect(v); m.Kind() == reflect.Map && m.Type().Key().Kind() == reflect.String {
+			fields = mapKeys(m)
+		} else {
+			types[v.Type()] = true
+			f, err := e.typeHeader(v.Type())
+			if err != nil {
+				return nil, err
+			}
+			fields = f
+		}
+		for _, f := range fields {
+			if !seen[f] {
+				seen[f] = true
+				keys = append(keys, f)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// headerName returns the CSV header field name for finfo with the encoder's
+// header style applied to names derived from Go struct field names.
+func (e *Encoder) headerName(finfo *fieldInfo) string {
+	if finfo.tagged {
+		return finfo.name
+	}
+	switch e.headerStyle {
+	case SnakeCase:
+		return strings.ToLower(strings.Join(splitWords(finfo.name), "_"))
+	case KebabCase:
+		return strings.ToLower(strings.Join(splitWords(finfo.name), "-"))
+	case LowerCase:
+		return strings.ToLower(finfo.name)
+	default:
+		return finfo.name
+	}
+}
+
+// styledNames returns the header names of all fields in tinfo of struct type
+// typ. Names are computed once per type and reused for all records.
+func (e *Encoder) styledNames(typ reflect.Type, tinfo *typeInfo) []string {
+	if names, ok := e.styled[typ]; ok {
+		return names
+	}
+	names := make([]string, len(tinfo.fields))
+	for i := range tinfo.fields {
+		names[i] = e.headerName(&tinfo.fields[i])
+	}
+	if e.styled == nil {
+		e.styled = make(map[reflect.Type][]string)
+	}
+	e.styled[typ] = names
+	return names
+}
+
+// splitWords splits a Go identifier like "HTTPServerName" into its words
+// "HTTP", "Server" and "Name".
+func splitWords(s string) []string {
+	var words []string
+	runes := []rune(s)
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		if cur == '_' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if !unicode.IsUpper(cur) || prev == '_' {
+			continue
+		}
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
 func (e *Encoder) output(fields []string) error {
-	// quote strings with whitespace and or separator
+	if e.rowHead != "" {
+		e.rowNo++
+		fields = e.withRowColumn(fields, strconv.Itoa(e.rowNo))
+	}
+	var names []string
+	if e.quoteFn != nil {
+		names = e.withRowColumn(e.headerKeys, e.rowHead)
+	}
+	line := e.formatLine(fields, false, names)
+	if e.prefixFn != nil {
+		line = e.prefixFn(e.record) + line
+	}
+	if e.suffixFn != nil {
+		line += e.suffixFn(e.record)
+	}
+	return e.writeString(line)
+}
+
+// withRowColumn prepends v to fields when a row number column is enabled.
+func (e *Encoder) withRowColumn(fields []string, v string) []string {
+	if e.rowHead == "" {
+		return fields
+	}
+	return append([]string{v}, fields...)
+}
+
+// writeLine writes fields as a single CSV line. When quoteAll is true, all
+// fields are quoted. Otherwise, when names contains the header field names of
+// a record, the quote function decides whether to quote each field.
+func (e *Encoder) writeLine(fields []string, quoteAll bool, names []string) error {
+	return e.writeString(e.formatLine(fields, quoteAll, names))
+}
+
+// formatLine quotes or escapes fields as needed and joins them into a line.
+func (e *Encoder) formatLine(fields []string, quoteAll bool, names []string) string {
+	// quote strings with whitespace, separators or quotes, escape quotes
+	quoted := make([]string, len(fields))
 	for i, v := range fields {
-		if !containsWhitespace(v) && !strings.Contains(v, e.sep) {
-			continue
+		if !quoteAll && names != nil {
+			var name string
+			if i < len(names) {
+				name = names[i]
+			}
+			if e.quoteFn(name, v) {
+				quoted[i] = Wrapper + strings.ReplaceAll(v, Wrapper, Wrapper+Wrapper) + Wrapper
+				continue
+			}
+		}
+		if quoteAll {
+			quoted[i] = Wrapper + strings.ReplaceAll(v, Wrapper, Wrapper+Wrapper) + Wrapper
+		} else if e.escapes {
+			quoted[i] = e.escape(v)
+		} else {
+			quoted[i] = e.quote(v)
 		}
-		fields[i] = strings.Join([]string{Wrapper, v, Wrapper}, "")
 	}
-	line := strings.Join(fields, string(e.sep))
+	line := strings.Join(quoted, string(e.sep))
+	if e.trailSep {
+		line += e.sep
+	}
+	return line
+}
+
+// writeString writes line followed by a newline to the output.
+func (e *Encoder) writeString(line string) error {
+	if e.keepOut && e.footer != nil {
+		e.written = append(append(e.written, line...), '\n')
+	}
 	if _, err := e.w.Write([]byte(line)); err != nil {
-		return fmt.Errorf("csv: %v", err)
+		e.werr = fmt.Errorf("csv: %v", err)
+		return e.werr
 	}
 	if _, err := e.w.Write([]byte("\n")); err != nil {
-		return fmt.Errorf("csv: %v", err)
+		e.werr = fmt.Errorf("csv: %v", err)
+		return e.werr
 	}
 	return nil
+}
 
+// quote wraps v in double quotes when it contains whitespace, the separator
+// or double quotes. Double quotes inside v are escaped by a second quote.
+func (e *Encoder) quote(v string) string {
+	if !containsWhitespace(v) && !strings.Contains(v, e.sep) && !strings.Contains(v, Wrapper) {
+		return v
+	}
+	v = strings.ReplaceAll(v, Wrapper, Wrapper+Wrapper)
+	if e.crlfQuote && strings.Contains(v, "\n") {
+		v = strings.ReplaceAll(strings.ReplaceAll(v, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return Wrapper + v + Wrapper
+}
+
+// escape replaces special characters in v by backslash escape sequences.
+func (e *Encoder) escape(v string) string {
+	pairs := []string{`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, Wrapper, `\` + Wrapper}
+	if !strings.ContainsAny(e.sep, "\\\t\n\r"+Wrapper) {
+		pairs = append(pairs, e.sep, `\`+e.sep)
+	}
+	return strings.NewReplacer(pairs...).Replace(v)
 }
 
 func containsWhitespace(s string) bool {
@@ -233,7 +1186,7 @@ func (e *Encoder) marshal(val reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		return e.output(fields)
+		return e.output(e.padFields(fields))
 	}
 
 	if val.CanAddr() {
@@ -243,7 +1196,7 @@ func (e *Encoder) marshal(val reflect.Value) error {
 			if err != nil {
 				return err
 			}
-			return e.output(fields)
+			return e.output(e.padFields(fields))
 		}
 	}
 
@@ -254,6 +1207,32 @@ func (e *Encoder) marshal(val reflect.Value) error {
 	// map struct fields
 	tokens := make([]string, len(e.headerKeys))
 
+	// map records are looked up by header field name
+	if val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String {
+		for i, fName := range e.headerKeys {
+			f := val.MapIndex(reflect.ValueOf(fName).Convert(val.Type().Key()))
+			if !f.IsValid() || !e.keepColumn(fName, val) {
+				continue
+			}
+			if isNil(f) {
+				tokens[i] = e.nullVal
+				continue
+			}
+			if f.Kind() == reflect.Interface || f.Kind() == reflect.Ptr {
+				f = f.Elem()
+			}
+			s, err := e.marshalValue(f)
+			if err != nil {
+				return err
+			}
+			if e.trim {
+				s = strings.TrimSpace(s)
+			}
+			tokens[i] = s
+		}
+		return e.output(tokens)
+	}
+
 	// work with []string, []interface{} and other slices with types than
 	// convert to string
 	if val.Kind() == reflect.Slice && val.Len() == len(e.headerKeys) {
@@ -261,12 +1240,14 @@ func (e *Encoder) marshal(val reflect.Value) error {
 			f := val.Index(i)
 			if f.Type().Kind() == reflect.Interface {
 				if f.IsNil() {
+					tokens[i] = e.nullVal
 					continue
 				}
 				f = f.Elem()
 			}
 			if f.Type().Kind() == reflect.Ptr {
 				if f.IsNil() {
+					tokens[i] = e.nullVal
 					continue
 				}
 				f = f.Elem()
@@ -274,22 +1255,34 @@ func (e *Encoder) marshal(val reflect.Value) error {
 			if !f.IsValid() {
 				continue
 			}
-			s, b, err := marshalSimple(f.Type(), f)
+			s, err := e.marshalValue(f)
 			if err != nil {
 				return err
 			}
-			if b != nil {
-				s = string(b)
-			}
 			tokens[i] = s
 		}
 	} else {
+		inline, err := e.inlineValues(val)
+		if err != nil {
+			return err
+		}
+		if err := e.checkAnyKeys(val); err != nil {
+			return err
+		}
 		for i, fName := range e.headerKeys {
 			// init with empty string
 			tokens[i] = ""
 
+			// use values of inlined marshalers
+			if v, ok := inline[fName]; ok {
+				if e.keepColumn(fName, val) {
+					tokens[i] = v
+				}
+				continue
+			}
+
 			finfo, f := e.findStructField(val, fName)
-			if finfo == nil || !f.IsValid() {
+			if finfo == nil {
 				continue
 			}
 
@@ -297,9 +1290,70 @@ func (e *Encoder) marshal(val reflect.Value) error {
 				continue
 			}
 
-			fv := finfo.value(val)
+			if !e.keepColumn(fName, val) {
+				continue
+			}
+
+			// fields of nil embedded structs are null
+			if !f.IsValid() {
+				tokens[i] = e.nullVal
+				continue
+			}
+
+			fv := f
+
+			// look up unmapped fields in `any` maps
+			if finfo.flags&fAny > 0 && fv.Kind() == reflect.Map {
+				if fv.Type().Key().Kind() != reflect.String {
+					continue
+				}
+				m := fv.MapIndex(reflect.ValueOf(fName).Convert(fv.Type().Key()))
+				if !m.IsValid() {
+					continue
+				}
+				s, err := e.marshalValue(reflect.Indirect(m))
+				if err != nil {
+					return err
+				}
+				tokens[i] = s
+				continue
+			}
 
 			if (fv.Kind() == reflect.Interface || fv.Kind() == reflect.Ptr) && fv.IsNil() {
+				tokens[i] = e.nullVal
+				continue
+			}
+
+			// distinguish nil from empty byte slices
+			if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8 && fv.IsNil() {
+				tokens[i] = e.nullVal
+				continue
+			}
+
+			// write zero values as empty fields when requested
+			if (!e.emitZero || finfo.flags&fOmitEmpty > 0) && reflect.Indirect(fv).IsZero() {
+				continue
+			}
+
+			// format time values with a per-field or default layout when
+			// requested
+			if layout := finfo.format; layout != "" || e.timeLayout() != "" {
+				if t, ok := timeValue(fv); ok {
+					if layout == "" {
+						layout = e.timeLayout()
+					}
+					tokens[i] = t.Format(layout)
+					continue
+				}
+			}
+
+			// join slice elements when requested
+			if k := reflect.Indirect(fv).Kind(); finfo.split != "" && (k == reflect.Slice || k == reflect.Array) {
+				s, err := e.joinSlice(reflect.Indirect(fv), finfo.split)
+				if err != nil {
+					return err
+				}
+				tokens[i] = s
 				continue
 			}
 
@@ -321,15 +1375,13 @@ func (e *Encoder) marshal(val reflect.Value) error {
 					} else {
 						tokens[i] = string(b)
 					}
+					continue
 				}
 			}
-			s, b, err := marshalSimple(f.Type(), f)
+			s, err := e.marshalValue(f)
 			if err != nil {
 				return err
 			}
-			if b != nil {
-				s = string(b)
-			}
 			tokens[i] = s
 
 			// trim
@@ -341,15 +1393,36 @@ func (e *Encoder) marshal(val reflect.Value) error {
 	return e.output(tokens)
 }
 
+// keepColumn reports whether field fName of record val should be written.
+func (e *Encoder) keepColumn(fName string, val reflect.Value) bool {
+	if e.colFilter == nil || !val.CanInterface() {
+		return true
+	}
+	return e.colFilter(fName, val.Interface())
+}
+
+// padFields appends empty fields up to the header length when padding is
+// enabled.
+func (e *Encoder) padFields(fields []string) []string {
+	if !e.pad {
+		return fields
+	}
+	for len(fields) < len(e.headerKeys) {
+		fields = append(fields, "")
+	}
+	return fields
+}
+
 func (e *Encoder) findStructField(val reflect.Value, name string) (*fieldInfo, reflect.Value) {
 	typ := val.Type()
-	tinfo, err := getTypeInfo(typ)
+	tinfo, err := getTypeInfo(typ, e.tag)
 	if err != nil {
 		return nil, reflect.Value{}
 	}
 
 	var finfo *fieldInfo
 	any := -1
+	names := e.styledNames(typ, tinfo)
 	// pick the correct field based on name and flags
 	for i, v := range tinfo.fields {
 		// save `any` field in case
@@ -358,7 +1431,7 @@ func (e *Encoder) findStructField(val reflect.Value, name string) (*fieldInfo, r
 		}
 
 		// field name must match
-		if v.name != name {
+		if v.flags&(fLine|fRecord) > 0 || names[i] != name {
 			continue
 		}
 
@@ -375,21 +1448,143 @@ func (e *Encoder) findStructField(val reflect.Value, name string) (*fieldInfo, r
 		return nil, reflect.Value{}
 	}
 
-	// allocate memory for pointer values in structs
-	v := finfo.value(val)
-	if v.Type().Kind() == reflect.Ptr && v.IsNil() && v.CanSet() {
-		v.Set(reflect.New(v.Type().Elem()))
+	// never allocate, nil pointers are written as null values
+	return finfo, finfo.lookup(val)
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// joinSlice encodes all elements of slice or array val and joins them with sep.
+func (e *Encoder) joinSlice(val reflect.Value, sep string) (string, error) {
+	parts := make([]string, val.Len())
+	for i := range parts {
+		f := val.Index(i)
+		if f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				continue
+			}
+			f = f.Elem()
+		}
+		if f.CanInterface() && f.Type().Implements(textMarshalerType) {
+			b, err := f.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return "", err
+			}
+			parts[i] = string(b)
+			continue
+		}
+		s, err := e.marshalValue(f)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
 	}
+	return strings.Join(parts, sep), nil
+}
 
-	return finfo, v
+// timeValue returns the time.Time stored in val or false when val is not
+// a time.Time or a non-nil pointer to a time.Time.
+func timeValue(val reflect.Value) (time.Time, bool) {
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return time.Time{}, false
+		}
+		val = val.Elem()
+	}
+	if val.Type() != timeType || !val.CanInterface() {
+		return time.Time{}, false
+	}
+	return val.Interface().(time.Time), true
 }
 
-var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+// marshalValue converts val into its CSV representation and applies the
+// encoder's formatting options.
+func (e *Encoder) marshalValue(val reflect.Value) (string, error) {
+	// write times like struct fields instead of using their String method
+	if t, ok := timeValue(val); ok {
+		if layout := e.timeLayout(); layout != "" {
+			return t.Format(layout), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	}
+	s, b, err := marshalSimple(val.Type(), val, e.stringer)
+	if err != nil {
+		return "", err
+	}
+	if b != nil {
+		s = string(b)
+	}
+	if e.stringer && val.Type().Implements(stringerType) {
+		return s, nil
+	}
+	switch val.Kind() {
+	case reflect.Bool:
+		if e.localeFmt.yes != "" {
+			if val.Bool() {
+				return e.localeFmt.yes, nil
+			}
+			return e.localeFmt.no, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if e.printer != nil {
+			return e.printer.Sprint(val.Int()), nil
+		}
+		if e.intGroup != 0 {
+			s = groupDigits(s, e.intGroup)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if e.printer != nil {
+			return e.printer.Sprint(val.Uint()), nil
+		}
+		if e.intGroup != 0 {
+			s = groupDigits(s, e.intGroup)
+		}
+	case reflect.Float32:
+		if e.printer != nil {
+			return e.printer.Sprint(number.Decimal(float32(val.Float()), number.MaxFractionDigits(-1))), nil
+		}
+	case reflect.Float64:
+		if e.printer != nil {
+			return e.printer.Sprint(number.Decimal(val.Float(), number.MaxFractionDigits(-1))), nil
+		}
+	}
+	return s, nil
+}
 
-func marshalSimple(typ reflect.Type, val reflect.Value) (string, []byte, error) {
-	if typ.Implements(stringerType) {
+// groupDigits inserts rune r between groups of three digits in integer number
+// s. Strings other than integer numbers are returned unchanged.
+func groupDigits(s string, r rune) string {
+	digits := strings.TrimPrefix(s, "-")
+	if len(digits) <= 3 || strings.IndexFunc(digits, func(c rune) bool { return c < '0' || c > '9' }) >= 0 {
+		return s
+	}
+	var b strings.Builder
+	if len(digits) < len(s) {
+		b.WriteByte('-')
+	}
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(r)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// marshalSimple converts val of type typ into its CSV representation. Types
+// implementing fmt.Stringer are converted by calling String unless they are
+// based on string, in which case the underlying string is used to keep
+// encoding symmetric to decoding. url.URL values are written as string as well.
+// When stringer is false, String is never called and the underlying value is
+// used instead.
+func marshalSimple(typ reflect.Type, val reflect.Value, stringer bool) (string, []byte, error) {
+	if stringer && typ.Kind() != reflect.String && typ.Implements(stringerType) {
 		return val.Interface().(fmt.Stringer).String(), nil, nil
 	}
+	if typ == urlType {
+		u := val.Interface().(url.URL)
+		return u.String(), nil, nil
+	}
 	switch val.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return strconv.FormatInt(val.Int(), 10), nil, nil
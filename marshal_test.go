@@ -16,7 +16,15 @@ package csv
 
 import (
 	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 var LF = []byte{'\n'}
@@ -68,10 +76,27 @@ func TestMarshalToWriter(t *testing.T) {
 }
 
 func TestMarshalNoSlice(t *testing.T) {
-	_, err := Marshal(A1)
+	_, err := Marshal(42)
 	if err == nil {
 		t.Errorf("expected error when calling without slice")
 	}
+	_, err = Marshal(make(chan A))
+	if err == nil {
+		t.Errorf("expected error when calling with channel")
+	}
+}
+
+func TestMarshalSingleStruct(t *testing.T) {
+	b, err := Marshal(A1)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, CsvWithHeaderOut)
+	b, err = Marshal(&A1)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, CsvWithHeaderOut)
 }
 
 func TestMarshalWithoutHeader(t *testing.T) {
@@ -125,3 +150,933 @@ func TestMarshalWithSeparator(t *testing.T) {
 	}
 	CheckOutput(t, w.Bytes(), CsvSemicolonOut)
 }
+
+type TimeFormats struct {
+	Date      time.Time `csv:"date,format=2006-01-02"`
+	Timestamp time.Time `csv:"ts,format=2006-01-02T15:04:05Z07:00"`
+}
+
+const CsvTimeFormatsOut = "date,ts\n2017-06-01,2017-06-01T12:30:45Z\n"
+
+func TestMarshalTimeFormats(t *testing.T) {
+	ts := time.Date(2017, 6, 1, 12, 30, 45, 0, time.UTC)
+	a := []TimeFormats{{ts, ts}}
+	b, err := Marshal(a)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, CsvTimeFormatsOut)
+
+	c := make([]TimeFormats, 0)
+	if err := Unmarshal(b, &c); err != nil {
+		t.Error(err)
+	}
+	if len(c) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(c), 1)
+		return
+	}
+	if exp := ts.Truncate(24 * time.Hour); !c[0].Date.Equal(exp) {
+		t.Errorf("invalid date got=%s expected=%s", c[0].Date, exp)
+	}
+	if !c[0].Timestamp.Equal(ts) {
+		t.Errorf("invalid timestamp got=%s expected=%s", c[0].Timestamp, ts)
+	}
+}
+
+type Person struct {
+	FirstName string
+	LastName  string
+	HTTPPort  int
+	Nick      string `csv:"NickName"`
+}
+
+func TestMarshalHeaderStyle(t *testing.T) {
+	p := []Person{{"John", "Doe", 80, "JD"}}
+	for _, v := range []struct {
+		Style HeaderStyle
+		Out   string
+	}{
+		{AsIs, "FirstName,LastName,HTTPPort,NickName\nJohn,Doe,80,JD\n"},
+		{SnakeCase, "first_name,last_name,http_port,NickName\nJohn,Doe,80,JD\n"},
+		{KebabCase, "first-name,last-name,http-port,NickName\nJohn,Doe,80,JD\n"},
+		{LowerCase, "firstname,lastname,httpport,NickName\nJohn,Doe,80,JD\n"},
+	} {
+		var w bytes.Buffer
+		enc := NewEncoder(&w).HeaderStyle(v.Style)
+		if err := enc.Encode(p); err != nil {
+			t.Error(err)
+		}
+		CheckOutput(t, w.Bytes(), v.Out)
+	}
+}
+
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	sizes []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.sizes = append(r.sizes, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+func TestMarshalFlushEvery(t *testing.T) {
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	enc := NewEncoder(w).FlushEvery(2)
+	a := []A{A1, A1, A1, A1, A1}
+	if err := enc.Encode(a); err != nil {
+		t.Error(err)
+	}
+	if len(w.sizes) != 2 {
+		t.Errorf("invalid flush count got=%d expected=%d", len(w.sizes), 2)
+		return
+	}
+	head, rec := len("s,b,i,f\n"), len(CsvWithoutHeaderOut)
+	if exp := head + 2*rec; w.sizes[0] != exp {
+		t.Errorf("invalid first flush size got=%d expected=%d", w.sizes[0], exp)
+	}
+	if exp := head + 4*rec; w.sizes[1] != exp {
+		t.Errorf("invalid second flush size got=%d expected=%d", w.sizes[1], exp)
+	}
+	if !w.Flushed {
+		t.Errorf("expected response to be flushed")
+	}
+}
+
+func TestMarshalMap(t *testing.T) {
+	m := map[string]string{
+		"s": "Hello",
+		"b": "true",
+		"i": "42",
+		"f": "23.45",
+	}
+	b, err := MarshalMap(m)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "b,f,i,s\ntrue,23.45,42,Hello\n")
+
+	if _, err := MarshalMap(A1); err == nil {
+		t.Errorf("expected error when calling without map")
+	}
+
+	// values are formatted like struct fields
+	at := time.Date(2020, 5, 17, 14, 30, 0, 0, time.UTC)
+	v := map[string]interface{}{"at": at, "day": &at, "n": 1234.5}
+	b, err = MarshalMap(v)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "at,day,n\n2020-05-17T14:30:00Z,2020-05-17T14:30:00Z,1234.5\n")
+
+	b, err = MarshalMap(v, func(e *Encoder) {
+		e.Separator(';').Locale(language.German).TimeFormats("2006-01-02")
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "at;day;n\n2020-05-17;2020-05-17;1.234,5\n")
+}
+
+type Inner struct {
+	X string `csv:"x"`
+	Y string `csv:"y"`
+}
+
+type Outer struct {
+	First string `csv:"first"`
+	Inner
+	Middle string `csv:"middle"`
+	*Other
+	Last string `csv:"last"`
+}
+
+type Other struct {
+	Z string `csv:"z"`
+}
+
+func TestMarshalEmbeddedOrder(t *testing.T) {
+	o := []Outer{{"1", Inner{"2", "3"}, "4", &Other{"5"}, "6"}}
+	b, err := Marshal(o)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "first,x,y,middle,z,last\n1,2,3,4,5,6\n")
+}
+
+type Shadow struct {
+	Inner
+	X string `csv:"x"`
+}
+
+func TestShadowEmbeddedField(t *testing.T) {
+	s := make([]Shadow, 0)
+	if err := Unmarshal([]byte("x,y\n1,2"), &s); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(s), 1)
+		return
+	}
+	if s[0].X != "1" || s[0].Inner.X != "" || s[0].Y != "2" {
+		t.Errorf("invalid shadowed field values got=%+v", s[0])
+	}
+	b, err := Marshal(s)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "y,x\n2,1\n")
+}
+
+type Short struct{}
+
+func (x Short) MarshalCSV() ([]string, error) {
+	return []string{"1", "2"}, nil
+}
+
+func TestMarshalPadRecords(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).PadRecords(true)
+	if err := enc.EncodeHeader([]string{"a", "b", "c", "d"}, nil); err != nil {
+		t.Error(err)
+	}
+	if err := enc.EncodeRecord(Short{}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "a,b,c,d\n1,2,,\n")
+}
+
+type AOrdered struct {
+	String string  `csv:"s,order=4"`
+	Bool   bool    `csv:"b,order=1"`
+	Other  string  `csv:"o"`
+	Int    int64   `csv:"i,order=3"`
+	Float  float64 `csv:"f,order=2"`
+}
+
+func TestMarshalFieldOrder(t *testing.T) {
+	a := []AOrdered{{"Hello", true, "x", 42, 23.45}}
+	b, err := Marshal(a)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "b,f,i,s,o\ntrue,23.45,42,Hello,x\n")
+
+	c := make([]AOrdered, 0)
+	dec := NewDecoder(bytes.NewReader(b[bytes.IndexByte(b, '\n')+1:])).Header(false)
+	if err := dec.Decode(&c); err != nil {
+		t.Error(err)
+	}
+	if len(c) != 1 || c[0] != a[0] {
+		t.Errorf("invalid headerless decode got=%+v expected=%+v", c, a)
+	}
+}
+
+type Zero struct {
+	Int   int       `csv:"i"`
+	Bool  bool      `csv:"b"`
+	Time  time.Time `csv:"t,format=2006-01-02"`
+	Float float64   `csv:"f,omitempty"`
+}
+
+func TestMarshalEmitZero(t *testing.T) {
+	z := []Zero{{}}
+	b, err := Marshal(z)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "i,b,t,f\n0,false,0001-01-01,\n")
+
+	var w bytes.Buffer
+	if err := NewEncoder(&w).EmitZero(false).Encode(z); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "i,b,t,f\n,,,\n")
+}
+
+func TestMarshalNilElements(t *testing.T) {
+	a := []*A{nil, &A1, nil, &A2}
+	b, err := Marshal(a)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "s,b,i,f\n,,,\nHello,true,42,23.45\n,,,\n\"Hello World\",false,43,24.56\n")
+
+	var w bytes.Buffer
+	if err := NewEncoder(&w).SkipNil(true).Encode(a); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f\nHello,true,42,23.45\n\"Hello World\",false,43,24.56\n")
+}
+
+type Failing struct {
+	Fail bool
+}
+
+func (x Failing) MarshalCSV() ([]string, error) {
+	if x.Fail {
+		return nil, fmt.Errorf("failed")
+	}
+	return []string{"ok"}, nil
+}
+
+func TestMarshalContinueOnError(t *testing.T) {
+	f := []Failing{{false}, {true}, {false}}
+	if _, err := Marshal(f); err == nil {
+		t.Errorf("expected error from failing marshaler")
+	}
+
+	var w bytes.Buffer
+	enc := NewEncoder(&w).ContinueOnError(true)
+	if err := enc.EncodeHeader([]string{"v"}, nil); err != nil {
+		t.Error(err)
+	}
+	err := enc.Encode(f)
+	errs, ok := err.(MultiError)
+	if !ok {
+		t.Errorf("invalid error type %T", err)
+		return
+	}
+	if len(errs) != 1 {
+		t.Errorf("invalid error count got=%d expected=%d", len(errs), 1)
+		return
+	}
+	if e, ok := errs[0].(*EncodeError); !ok || e.Index() != 1 {
+		t.Errorf("invalid error got=%v", errs[0])
+	}
+	CheckOutput(t, w.Bytes(), "v\nok\nok\n")
+}
+
+type Code string
+
+func (c Code) String() string {
+	return "Code(" + string(c) + ")"
+}
+
+type NamedString struct {
+	Code Code `csv:"code"`
+}
+
+func TestMarshalNamedStringRoundTrip(t *testing.T) {
+	n := []NamedString{{"ABC"}}
+	b, err := Marshal(n)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, "code\nABC\n")
+	m := make([]NamedString, 0)
+	if err := Unmarshal(b, &m); err != nil {
+		t.Error(err)
+	}
+	if len(m) != 1 || m[0] != n[0] {
+		t.Errorf("invalid round-trip got=%v expected=%v", m, n)
+	}
+}
+
+func TestMarshalTrailingSeparator(t *testing.T) {
+	var w bytes.Buffer
+	a := []A{A1, A2}
+	if err := NewEncoder(&w).TrailingSeparator(true).Encode(a); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f,\nHello,true,42,23.45,\n\"Hello World\",false,43,24.56,\n")
+
+	c := make([]A, 0)
+	dec := NewDecoder(bytes.NewReader(w.Bytes())).SkipUnknown(false).TrimTrailingSeparator(true)
+	if err := dec.Decode(&c); err != nil {
+		t.Error(err)
+	}
+	if len(c) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(c), 2)
+		return
+	}
+	CheckA(t, &c[0], A1)
+	CheckA(t, &c[1], A2)
+}
+
+type Big struct {
+	Int  int64  `csv:"i"`
+	Uint uint64 `csv:"u"`
+}
+
+func TestMarshalIntGrouping(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).IntGrouping(',')
+	if err := enc.Encode([]Big{{-1234567, 1000000}, {-123, 999}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "i,u\n\"-1,234,567\",\"1,000,000\"\n-123,999\n")
+
+	w.Reset()
+	enc = NewEncoder(&w).IntGrouping('.').Separator(';')
+	if err := enc.Encode([]Big{{-1234567, 1000000}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "i;u\n-1.234.567;1.000.000\n")
+}
+
+func TestMarshalFooter(t *testing.T) {
+	var w bytes.Buffer
+	var size int
+	enc := NewEncoder(&w).KeepOutput(true).Footer(func(n int, b []byte) string {
+		size = len(b)
+		return fmt.Sprintf("#rows=%d", n)
+	})
+	if err := enc.Encode([]A{A1, A2}); err != nil {
+		t.Error(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Error(err)
+	}
+	out := "s,b,i,f\nHello,true,42,23.45\n\"Hello World\",false,43,24.56\n"
+	CheckOutput(t, w.Bytes(), out+"#rows=2\n")
+	if size != len(out) {
+		t.Errorf("invalid footer input size got=%d expected=%d", size, len(out))
+	}
+
+	a := make([]A, 0)
+	if err := Unmarshal(w.Bytes(), &a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+	}
+
+	// output is not kept by default, a hash sees all lines instead
+	w.Reset()
+	h := crc32.NewIEEE()
+	enc = NewEncoder(io.MultiWriter(&w, h)).Footer(func(n int, b []byte) string {
+		if b != nil {
+			t.Errorf("unexpected footer input of size %d", len(b))
+		}
+		return fmt.Sprintf("#crc=%08x", h.Sum32())
+	})
+	if err := enc.Encode([]A{A1, A2}); err != nil {
+		t.Error(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), out+fmt.Sprintf("#crc=%08x\n", crc32.ChecksumIEEE([]byte(out))))
+}
+
+type Amount struct {
+	Name  string  `csv:"name"`
+	Value float64 `csv:"value"`
+	Count int     `csv:"count"`
+}
+
+func TestMarshalLocale(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).Separator(';').Locale(language.German)
+	if err := enc.Encode([]Amount{{"x", 1234.5, 1234567}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name;value;count\nx;1.234,5;1.234.567\n")
+
+	// no scientific notation for very small and very large numbers
+	w.Reset()
+	enc = NewEncoder(&w).Separator(';').Locale(language.German)
+	if err := enc.Encode([]Amount{{"small", 0.000012345, 0}, {"large", 1e21, 0}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name;value;count\nsmall;0,000012345;0\nlarge;1.000.000.000.000.000.000.000;0\n")
+}
+
+type Booking struct {
+	Name string    `csv:"name"`
+	Paid bool      `csv:"paid"`
+	At   time.Time `csv:"at"`
+	Day  time.Time `csv:"day,format=2006-01-02"`
+}
+
+func TestMarshalLocaleTimeBool(t *testing.T) {
+	at := time.Date(2020, 5, 17, 14, 30, 0, 0, time.UTC)
+	v := []Booking{{"a", true, at, at}, {"b", false, at, at}}
+	tests := []struct {
+		tag language.Tag
+		out string
+	}{
+		{language.German, "name;paid;at;day\na;wahr;\"17.05.2020 14:30:00\";2020-05-17\nb;falsch;\"17.05.2020 14:30:00\";2020-05-17\n"},
+		{language.AmericanEnglish, "name;paid;at;day\na;true;\"05/17/2020 2:30:00 PM\";2020-05-17\nb;false;\"05/17/2020 2:30:00 PM\";2020-05-17\n"},
+		{language.BritishEnglish, "name;paid;at;day\na;true;\"17/05/2020 14:30:00\";2020-05-17\nb;false;\"17/05/2020 14:30:00\";2020-05-17\n"},
+		{language.Japanese, "name;paid;at;day\na;true;2020-05-17T14:30:00Z;2020-05-17\nb;false;2020-05-17T14:30:00Z;2020-05-17\n"},
+	}
+	for _, test := range tests {
+		var w bytes.Buffer
+		if err := NewEncoder(&w).Separator(';').Locale(test.tag).Encode(v); err != nil {
+			t.Error(err)
+		}
+		CheckOutput(t, w.Bytes(), test.out)
+	}
+
+	// explicit layouts take precedence
+	var w bytes.Buffer
+	enc := NewEncoder(&w).Separator(';').Locale(language.German).TimeFormats(time.Kitchen)
+	if err := enc.Encode(v[:1]); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name;paid;at;day\na;wahr;2:30PM;2020-05-17\n")
+}
+
+func TestMarshalSplit(t *testing.T) {
+	var w bytes.Buffer
+	scores := []int{1, 2, 3}
+	enc := NewEncoder(&w)
+	if err := enc.Encode([]Scores{{"Alice", &scores, []string{"a", "b"}}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,scores,tags\nAlice,1;2;3,a|b\n")
+}
+
+type Note struct {
+	Id   int    `csv:"id"`
+	Note string `csv:"note"`
+}
+
+func TestMarshalQuotedNewline(t *testing.T) {
+	for _, crlf := range []bool{false, true} {
+		var w bytes.Buffer
+		in := []Note{{1, "line1\nline2"}, {2, `say "hi"`}}
+		enc := NewEncoder(&w).QuotedCRLF(crlf)
+		if err := enc.Encode(in); err != nil {
+			t.Error(err)
+		}
+		nl := "\n"
+		if crlf {
+			nl = "\r\n"
+		}
+		CheckOutput(t, w.Bytes(), "id,note\n1,\"line1"+nl+"line2\"\n2,\"say \"\"hi\"\"\"\n")
+
+		out := make([]Note, 0)
+		if err := Unmarshal(w.Bytes(), &out); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("invalid round-trip got=%v expected=%v", out, in)
+		}
+	}
+}
+
+func TestMarshalRows(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w)
+	if err := enc.EncodeHeader([]string{"s", "i"}, nil); err != nil {
+		t.Error(err)
+	}
+	rows := [][]string{{"Hello", "42"}, {"Hello World", "43"}, {"a,b", "44"}}
+	if err := enc.EncodeRows(rows); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,i\nHello,42\n\"Hello World\",43\n\"a,b\",44\n")
+	if rows[1][0] != "Hello World" {
+		t.Errorf("input rows must not be modified, got=%q", rows[1][0])
+	}
+}
+
+func TestMarshalUnionHeader(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).UnionHeader(true)
+	v := []interface{}{
+		A{"Hello", true, 42, 23.45},
+		&Note{1, "note"},
+		nil,
+		A{"World", false, 43, 24.56},
+	}
+	if err := enc.Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f,id,note\nHello,true,42,23.45,,\n,,,,1,note\n,,,,,\nWorld,false,43,24.56,,\n")
+}
+
+func TestMarshalMapRecords(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte(CsvWithHeader)))
+	m := make([]map[string]string, 0)
+	if err := dec.Decode(&m); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// reuse the decoded header to reproduce the input
+	var w bytes.Buffer
+	enc := NewEncoder(&w)
+	if err := enc.EncodeHeader(dec.HeaderKeys(), nil); err != nil {
+		t.Error(err)
+	}
+	if err := enc.Encode(m); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), CsvWithHeader+"\n")
+
+	// derive a sorted header from map keys
+	w.Reset()
+	if err := NewEncoder(&w).Encode(m); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "b,f,i,s\ntrue,23.45,42,Hello\n")
+}
+
+func TestMarshalQuoteHeader(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).QuoteHeader(true)
+	if err := enc.EncodeHeader([]string{"s", `say "hi"`}, nil); err != nil {
+		t.Error(err)
+	}
+	if err := enc.EncodeRows([][]string{{"Hello", "42"}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "\"s\",\"say \"\"hi\"\"\"\nHello,42\n")
+}
+
+type Blob struct {
+	Name string  `csv:"name"`
+	Data []byte  `csv:"data"`
+	Ptr  *string `csv:"ptr"`
+}
+
+func TestMarshalNullValue(t *testing.T) {
+	v := []Blob{{"nil", nil, nil}, {"empty", []byte{}, nil}, {"data", []byte{0xca, 0xfe}, nil}}
+	var w bytes.Buffer
+	if err := NewEncoder(&w).Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,data,ptr\nnil,,\nempty,,\ndata,cafe,\n")
+
+	w.Reset()
+	if err := NewEncoder(&w).NullValue("NULL").Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,data,ptr\nnil,NULL,NULL\nempty,,NULL\ndata,cafe,NULL\n")
+}
+
+func TestEncodeReader(t *testing.T) {
+	r := EncodeReader([]A{A1, A2}, func(e *Encoder) { e.Separator(';') })
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, buf, "s;b;i;f\nHello;true;42;23.45\n\"Hello World\";false;43;24.56\n")
+
+	// encoding errors are returned by the reader
+	if _, err = io.ReadAll(EncodeReader(42)); err == nil {
+		t.Errorf("expected encoding error")
+	}
+
+	// closing the reader early stops encoding
+	r = EncodeReader(make([]A, 10000))
+	if _, err := r.Read(make([]byte, 16)); err != nil {
+		t.Error(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Read(make([]byte, 16)); err != io.ErrClosedPipe {
+		t.Errorf("invalid error after close got=%v expected=%v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestMarshalSecondaryHeader(t *testing.T) {
+	var w bytes.Buffer
+	enc := NewEncoder(&w).SecondaryHeader(map[string]string{"value": "EUR", "count": "pcs"})
+	if err := enc.Encode([]Amount{{"x", 1.5, 2}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,value,count\n,EUR,pcs\nx,1.5,2\n")
+}
+
+type Level int
+
+func (l Level) String() string {
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+type Sensor struct {
+	Name  string `csv:"name"`
+	Level Level  `csv:"level"`
+}
+
+func TestMarshalUseStringer(t *testing.T) {
+	var w bytes.Buffer
+	v := []Sensor{{"a", 3}}
+	if err := NewEncoder(&w).Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,level\na,Level(3)\n")
+
+	w.Reset()
+	if err := NewEncoder(&w).UseStringer(false).Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,level\na,3\n")
+}
+
+type Account struct {
+	Name         string `csv:"name"`
+	Secret       string `csv:"secret"`
+	Confidential bool   `csv:"confidential"`
+}
+
+func TestMarshalColumnFilter(t *testing.T) {
+	var w bytes.Buffer
+	v := []*Account{
+		{"a", "s1", false},
+		{"b", "s2", true},
+	}
+	enc := NewEncoder(&w).ColumnFilter(func(field string, record interface{}) bool {
+		return field != "secret" || !record.(Account).Confidential
+	})
+	if err := enc.Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,secret,confidential\na,s1,false\nb,,true\n")
+}
+
+type Event struct {
+	Name  string     `csv:"name"`
+	Start *time.Time `csv:"start"`
+	End   *time.Time `csv:"end,format=2006-01-02"`
+}
+
+func TestMarshalNullTime(t *testing.T) {
+	zero := time.Time{}
+	v := []Event{{"nil", nil, nil}, {"zero", &zero, &zero}}
+	var w bytes.Buffer
+	if err := NewEncoder(&w).NullValue("NULL").Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,start,end\nnil,NULL,NULL\nzero,0001-01-01T00:00:00Z,0001-01-01\n")
+
+	// addressable records must not be modified
+	w.Reset()
+	p := []*Event{{"nil", nil, nil}, {"zero", &zero, &zero}}
+	if err := NewEncoder(&w).NullValue("NULL").Encode(p); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,start,end\nnil,NULL,NULL\nzero,0001-01-01T00:00:00Z,0001-01-01\n")
+	if p[0].Start != nil || p[0].End != nil {
+		t.Errorf("encoder allocated nil pointer fields")
+	}
+
+	// nil pointers in slice records
+	w.Reset()
+	enc := NewEncoder(&w).NullValue("NULL")
+	if err := enc.EncodeHeader([]string{"name", "start"}, nil); err != nil {
+		t.Error(err)
+	}
+	if err := enc.EncodeRecord([]interface{}{"nil", (*time.Time)(nil)}); err != nil {
+		t.Error(err)
+	}
+	if err := enc.EncodeRecord([]interface{}{"zero", &zero}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,start\nnil,NULL\nzero,0001-01-01T00:00:00Z\n")
+}
+
+func TestMarshalAnyMap(t *testing.T) {
+	var w bytes.Buffer
+	v := []B{
+		{"Hello", true, 42, 23.45, map[string]string{"y": "2", "x": "1"}},
+		{"World", false, 43, 24.56, map[string]string{"x": "3"}},
+	}
+	if err := NewEncoder(&w).Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f,x,y\nHello,true,42,23.45,1,2\nWorld,false,43,24.56,3,\n")
+
+	// keys missing from the header must not be dropped silently
+	w.Reset()
+	v[1].Any["z"] = "4"
+	err := NewEncoder(&w).Encode(v)
+	if exp := `csv: key "z" of any map is not in header`; err == nil || err.Error() != exp {
+		t.Errorf("invalid error got=%v expected=%s", err, exp)
+	}
+}
+
+func TestMarshalRowNumber(t *testing.T) {
+	var w bytes.Buffer
+	if err := NewEncoder(&w).WithRowNumber("no").Encode([]A{A1, A2, A1}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "no,s,b,i,f\n1,Hello,true,42,23.45\n2,\"Hello World\",false,43,24.56\n3,Hello,true,42,23.45\n")
+}
+
+func TestMarshalBackslashEscapes(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"a\tb", `a\tb`},
+		{"a\nb", `a\nb`},
+		{"a\rb", `a\rb`},
+		{`a\b`, `a\\b`},
+		{`a\tb`, `a\\tb`},
+		{`"a"`, `\"a\"`},
+		{" a ", " a "},
+	}
+	for _, test := range tests {
+		var w bytes.Buffer
+		enc := NewTSVEncoder(&w)
+		if err := enc.EncodeHeader([]string{"s", "i"}, nil); err != nil {
+			t.Error(err)
+		}
+		if err := enc.EncodeRecord(A{String: test.in, Int: 42}); err != nil {
+			t.Error(err)
+		}
+		CheckOutput(t, w.Bytes(), "s\ti\n"+test.out+"\t42\n")
+
+		// values round-trip through the decoder
+		a := make([]A, 0)
+		if err := NewTSVDecoder(&w).Decode(&a); err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(a) != 1 || a[0].String != test.in {
+			t.Errorf("%q: invalid round trip got=%v", test.in, a)
+		}
+	}
+
+	// separators other than tab are escaped as well
+	var w bytes.Buffer
+	enc := NewEncoder(&w).BackslashEscapes(true)
+	if err := enc.Encode([]A{{String: "a,b c", Int: 1}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f\na\\,b c,false,1,0\n")
+}
+
+func TestMarshalTagName(t *testing.T) {
+	var w bytes.Buffer
+	v := []JSONTagged{{"x", 0, 1.5, "y"}}
+	if err := NewEncoder(&w).TagName("json").Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,count,price\nx,,1.5\n")
+}
+
+type Dimensions struct {
+	Width  int
+	Height int
+}
+
+func (d *Dimensions) MarshalCSV() ([]string, error) {
+	return []string{fmt.Sprint(d.Width), fmt.Sprint(d.Height)}, nil
+}
+
+func (d Dimensions) MarshalCSVHeader() []string {
+	return []string{"width", "height"}
+}
+
+type Offset struct {
+	X, Y int
+}
+
+func (o Offset) MarshalCSV() ([]string, error) {
+	return []string{fmt.Sprint(o.X), fmt.Sprint(o.Y)}, nil
+}
+
+func (o Offset) MarshalCSVHeader() []string {
+	return []string{"x", "y"}
+}
+
+type Image struct {
+	Name   string     `csv:"name"`
+	Size   Dimensions `csv:"size,inline"`
+	Offset *Offset    `csv:"offset,inline"`
+}
+
+func TestMarshalInline(t *testing.T) {
+	var w bytes.Buffer
+	v := []Image{{"a", Dimensions{640, 480}, &Offset{1, 2}}, {"b", Dimensions{320, 240}, nil}}
+	if err := NewEncoder(&w).NullValue("-").Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "name,width,height,x,y\na,640,480,1,2\nb,320,240,-,-\n")
+
+	// values must match the inlined header
+	w.Reset()
+	err := NewEncoder(&w).Encode([]Framed{{"a", Ragged{}}})
+	if exp := "csv: inline field frame has 1 values for 2 header fields"; err == nil || err.Error() != exp {
+		t.Errorf("invalid error got=%v expected=%s", err, exp)
+	}
+}
+
+type Ragged struct{}
+
+func (r Ragged) MarshalCSV() ([]string, error) {
+	return []string{"x"}, nil
+}
+
+func (r Ragged) MarshalCSVHeader() []string {
+	return []string{"top", "left"}
+}
+
+type Framed struct {
+	Name  string `csv:"name"`
+	Frame Ragged `csv:"frame,inline"`
+}
+
+func TestMarshalQuoteFunc(t *testing.T) {
+	var w bytes.Buffer
+	v := []Note{{1, "plain"}, {2, "with \"space\""}}
+	enc := NewEncoder(&w).QuoteFunc(func(field, value string) bool {
+		return field == "note"
+	})
+	if err := enc.Encode(v); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "id,note\n1,\"plain\"\n2,\"with \"\"space\"\"\"\n")
+
+	// default rules still apply when fn returns false
+	w.Reset()
+	enc = NewEncoder(&w).QuoteFunc(func(field, value string) bool {
+		return false
+	})
+	if err := enc.Encode([]Note{{1, "a,b"}, {2, "plain"}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "id,note\n1,\"a,b\"\n2,plain\n")
+}
+
+func TestMarshalLinePrefix(t *testing.T) {
+	var (
+		w   bytes.Buffer
+		seq int
+	)
+	enc := NewEncoder(&w).LinePrefix(func(record interface{}) string {
+		seq++
+		return fmt.Sprintf("#seq=%d\n", seq)
+	}).LineSuffix(func(record interface{}) string {
+		if a, ok := record.(A); ok && !a.Bool {
+			return ",END"
+		}
+		return ""
+	})
+	if err := enc.Encode([]A{A1, {String: "x", Int: 1}}); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), "s,b,i,f\n#seq=1\nHello,true,42,23.45\n#seq=2\nx,false,1,0,END\n")
+
+	// comment prefixes are skipped by the decoder
+	w.Reset()
+	seq = 0
+	enc = NewEncoder(&w).LinePrefix(func(interface{}) string {
+		seq++
+		return fmt.Sprintf("#seq=%d\n", seq)
+	})
+	if err := enc.Encode([]A{A1, A2}); err != nil {
+		t.Error(err)
+	}
+	a := make([]*A, 0)
+	if err := Unmarshal(w.Bytes(), &a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+}
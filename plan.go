@@ -0,0 +1,194 @@
+// Copyright (c) 2017 Alexander Eichhorn
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package csv
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Plan is a precompiled mapping from CSV header fields to the fields of a
+// struct type. It resolves field lookup and value conversion once so that
+// records can be decoded without repeating the per-row reflection work.
+//
+// A Plan is bound to the Decoder that created it and uses the Decoder's
+// current settings and header. It is not safe for concurrent use.
+type Plan struct {
+	d           *Decoder
+	typ         reflect.Type
+	columns     []planColumn
+	unmarshaler bool
+}
+
+type planColumn struct {
+	finfo *fieldInfo
+	set   func(reflect.Value, string) error
+}
+
+// Prepare builds a decoding plan for the struct type of typeHint. When the
+// Decoder expects a header, it must already have been processed with
+// DecodeHeader. Otherwise the header is derived from the type definition.
+func (d *Decoder) Prepare(typeHint interface{}) (*Plan, error) {
+	typ := reflect.TypeOf(typeHint)
+	if typ == nil {
+		return nil, fmt.Errorf("csv: nil type hint passed to Prepare")
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	typ = indirectType(typ)
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("csv: Prepare requires a struct type, got %s", typ)
+	}
+
+	if len(d.headerKeys) == 0 {
+		if d.readHeader {
+			return nil, fmt.Errorf("csv: header must be decoded before Prepare")
+		}
+		if err := d.typeHeader(typ); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &Plan{
+		d:           d,
+		typ:         typ,
+		unmarshaler: reflect.PtrTo(typ).Implements(unmarshalerType) || typ.Implements(unmarshalerType),
+	}
+	if p.unmarshaler {
+		return p, nil
+	}
+
+	tinfo, err := getTypeInfo(typ, d.tag)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %v", err)
+	}
+
+	// resolve header fields once; explicitly mapped fields take precedence
+	// over the `any` field just like in DecodeRecord
+	d.unknown = make([]string, 0)
+	mapped := make(map[string]bool)
+	p.columns = make([]planColumn, len(d.headerKeys))
+	for i, fName := range d.headerKeys {
+		if d.skipCols[i] {
+			continue
+		}
+		if d.lookupField(tinfo, fName, false) == nil {
+			d.unknown = append(d.unknown, fName)
+		}
+		finfo := d.lookupField(tinfo, fName, mapped[fName])
+		if finfo == nil {
+			if d.skipUnknown {
+				continue
+			}
+			return nil, &DecodeError{d.lineNo, i + 1, fName, fmt.Errorf("field not found")}
+		}
+		if finfo.flags&fAny == 0 {
+			mapped[fName] = true
+		}
+		ftyp := typ.FieldByIndex(finfo.idx).Type
+		p.columns[i] = planColumn{
+			finfo: finfo,
+			set:   d.fieldSetter(finfo, ftyp, fName),
+		}
+	}
+	return p, nil
+}
+
+// DecodeInto extracts CSV record fields from line and stores them into v,
+// which must be a pointer to the type the plan was prepared for. Any previous
+// content of v is reset before decoding.
+func (p *Plan) DecodeInto(line string, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Type().Elem() != p.typ {
+		return fmt.Errorf("csv: DecodeInto requires a non-nil *%s", p.typ)
+	}
+	val = val.Elem()
+	val.Set(reflect.Zero(p.typ))
+
+	d := p.d
+	if p.unmarshaler {
+		return d.unmarshal(val.Addr(), line)
+	}
+
+	// split line into tokens
+	tokens, err := d.split(line)
+	if err != nil {
+		return &DecodeError{d.lineNo, 0, "", err}
+	}
+
+	// recover numbers with grouping separators that were split into fields
+	if d.mergeNums && len(tokens) > len(d.headerKeys) {
+		tokens = d.mergeNumeric(p.typ, tokens)
+	}
+
+	if len(tokens) != len(d.headerKeys) {
+		return &DecodeError{d.lineNo, 0, "number of fields does not match header", nil}
+	}
+
+	if d.stats != nil {
+		d.collectStats(tokens)
+	}
+
+	if err := d.checkRequired(tokens); err != nil {
+		return err
+	}
+
+	// store the record's line number and raw fields
+	if err := d.setLineNo(val); err != nil {
+		return err
+	}
+	if err := d.setRecord(val, tokens); err != nil {
+		return err
+	}
+
+	var filled map[int]bool
+	if d.omitEmbed {
+		filled = make(map[int]bool)
+	}
+	for i, col := range p.columns {
+		if col.set == nil {
+			continue
+		}
+		s := tokens[i]
+		if d.trim {
+			s = d.trimValue(s)
+		}
+		if d.nullVals[s] {
+			s = ""
+		}
+
+		// allocate memory for pointer values in structs
+		f := col.finfo.value(val)
+		if f.Kind() == reflect.Ptr && f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+
+		if err := col.set(f, s); err != nil {
+			return &DecodeError{d.lineNo, i + 1, d.headerKeys[i], err}
+		}
+		if filled != nil && s != "" {
+			filled[col.finfo.idx[0]] = true
+		}
+	}
+
+	if d.omitEmbed {
+		clearEmbedded(val, filled)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2017 Alexander Eichhorn
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package csv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPlanDecodeInto(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvWithoutLF))
+	dec := NewDecoder(r)
+	line, err := dec.ReadLine()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err = dec.DecodeHeader(line); err != nil {
+		t.Error(err)
+		return
+	}
+	plan, err := dec.Prepare(A{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a := &A{}
+	for _, v := range []A{A1, A2} {
+		line, err = dec.ReadLine()
+		if err != nil && err != io.EOF {
+			t.Error(err)
+			return
+		}
+		if err = plan.DecodeInto(line, a); err != nil {
+			t.Error(err)
+			return
+		}
+		CheckA(t, a, v)
+	}
+}
+
+func TestPlanDecodeIntoAny(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvAnyFields))
+	dec := NewDecoder(r)
+	line, _ := dec.ReadLine()
+	if _, err := dec.DecodeHeader(line); err != nil {
+		t.Error(err)
+		return
+	}
+	plan, err := dec.Prepare(&B{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	line, _ = dec.ReadLine()
+	b := &B{}
+	if err = plan.DecodeInto(line, b); err != nil {
+		t.Error(err)
+		return
+	}
+	CheckB(t, b, X1)
+	if err = plan.DecodeInto(line, &A{}); err == nil {
+		t.Errorf("expected error for mismatched type")
+	}
+}
+
+func TestPlanUnknownField(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(nil))
+	if _, err := dec.DecodeHeader("s,i,f,b,x"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := dec.Prepare(A{}); err != nil {
+		t.Error(err)
+	}
+	dec.SkipUnknown(false)
+	if _, err := dec.Prepare(A{}); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+}
+
+func BenchmarkDecodeRecord(b *testing.B) {
+	dec := NewDecoder(bytes.NewReader(nil))
+	dec.DecodeHeader("s,i,f,b")
+	a := &A{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := dec.DecodeRecord(a, "Hello World,43,24.56,false"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPlanDecodeInto(b *testing.B) {
+	dec := NewDecoder(bytes.NewReader(nil))
+	dec.DecodeHeader("s,i,f,b")
+	plan, err := dec.Prepare(A{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	a := &A{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := plan.DecodeInto("Hello World,43,24.56,false", a); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 Alexander Eichhorn
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package csv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Dialect describes the syntax of a CSV file. Zero values select the package
+// defaults.
+type Dialect struct {
+	Separator rune // field separator, defaults to ','
+	Comment   rune // comment line identifier, defaults to '#'
+}
+
+func (d Dialect) separator() rune {
+	if d.Separator == 0 {
+		return Separator
+	}
+	return d.Separator
+}
+
+func (d Dialect) comment() rune {
+	if d.Comment == 0 {
+		return Comment
+	}
+	return d.Comment
+}
+
+// Transform reads CSV records from r in dialect in and writes them to w in
+// dialect out without decoding them into Go values. The input must contain
+// a header. colOrder selects and orders the header fields written to the
+// output. When colOrder is empty, all fields are written in input order.
+func Transform(r io.Reader, w io.Writer, in, out Dialect, colOrder []string) error {
+	dec := NewDecoder(r).Separator(in.separator()).Comment(in.comment())
+	enc := NewEncoder(w).Separator(out.separator())
+
+	line, err := dec.ReadLine()
+	if err != nil || line == "" {
+		return err
+	}
+	header, err := dec.DecodeHeader(line)
+	if err != nil {
+		return err
+	}
+	if len(colOrder) == 0 {
+		colOrder = header
+	}
+
+	// map output columns to input field positions
+	pos := make([]int, len(colOrder))
+	for i, name := range colOrder {
+		pos[i] = -1
+		for j, v := range header {
+			if v == name {
+				pos[i] = j
+				break
+			}
+		}
+		if pos[i] < 0 {
+			return fmt.Errorf("csv: unknown column %q", name)
+		}
+	}
+
+	if err := enc.output(append([]string{}, colOrder...)); err != nil {
+		return err
+	}
+
+	for {
+		line, err := dec.ReadLine()
+		if err != nil || line == "" {
+			return err
+		}
+		tokens, err := dec.split(line)
+		if err != nil {
+			return &DecodeError{dec.lineNo, 0, "", err}
+		}
+		if len(tokens) != len(header) {
+			return &DecodeError{dec.lineNo, 0, "number of fields does not match header", nil}
+		}
+		record := make([]string, len(pos))
+		for i, j := range pos {
+			record[i] = tokens[j]
+		}
+		if err := enc.output(record); err != nil {
+			return err
+		}
+	}
+}
+
+// RoundTrip encodes slice v and decodes the result back into a new slice of
+// the same type, which is returned. It is intended for tests that check
+// whether values survive encoding and decoding unchanged, for example with
+// reflect.DeepEqual. v must be a slice or a pointer to a slice.
+//
+// Each option in opts must be a func(*Encoder), a func(*Decoder) or a Dialect.
+// Encoder and decoder options are applied in order before encoding and
+// decoding starts. A Dialect applies to both sides.
+//
+//     v, err := csv.RoundTrip(in,
+//         csv.Dialect{Separator: ';'},
+//         func(e *csv.Encoder) { e.NullValue("NULL") },
+//         func(d *csv.Decoder) { d.NullValue("NULL") },
+//     )
+func RoundTrip(v interface{}, opts ...interface{}) (interface{}, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("csv: non-slice passed to RoundTrip")
+	}
+
+	var (
+		b       bytes.Buffer
+		encOpts []func(*Encoder)
+		decOpts []func(*Decoder)
+	)
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case func(*Encoder):
+			encOpts = append(encOpts, o)
+		case func(*Decoder):
+			decOpts = append(decOpts, o)
+		case Dialect:
+			encOpts = append(encOpts, func(e *Encoder) { e.Separator(o.separator()) })
+			decOpts = append(decOpts, func(d *Decoder) { d.Separator(o.separator()).Comment(o.comment()) })
+		default:
+			return nil, fmt.Errorf("csv: invalid RoundTrip option of type %T", opt)
+		}
+	}
+
+	enc := NewEncoder(&b)
+	for _, opt := range encOpts {
+		opt(enc)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	val := reflect.New(typ)
+	val.Elem().Set(reflect.MakeSlice(typ, 0, 0))
+	dec := NewDecoder(&b)
+	for _, opt := range decOpts {
+		opt(dec)
+	}
+	if err := dec.Decode(val.Interface()); err != nil {
+		return nil, err
+	}
+	return val.Elem().Interface(), nil
+}
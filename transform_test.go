@@ -0,0 +1,101 @@
+// Copyright (c) 2017 Alexander Eichhorn
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package csv
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const (
+	CsvTransformIn = `s,i,f,b
+# comment
+"Hello, World",42,23.45,true
+Hello,43,24.56,false`
+	CsvTransformOut = "b;s\ntrue;\"Hello, World\"\nfalse;Hello\n"
+)
+
+func TestTransform(t *testing.T) {
+	var w bytes.Buffer
+	r := bytes.NewReader([]byte(CsvTransformIn))
+	err := Transform(r, &w, Dialect{}, Dialect{Separator: ';'}, []string{"b", "s"})
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, w.Bytes(), CsvTransformOut)
+}
+
+func TestTransformUnknownColumn(t *testing.T) {
+	var w bytes.Buffer
+	r := bytes.NewReader([]byte(CsvTransformIn))
+	if err := Transform(r, &w, Dialect{}, Dialect{}, []string{"x"}); err == nil {
+		t.Errorf("expected error for unknown column")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	a := []A{A1, A2, {"Hello, World", true, -1, 0.5}}
+	v, err := RoundTrip(a)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(v, a) {
+		t.Errorf("invalid round trip got=%v expected=%v", v, a)
+	}
+
+	b := []*B{
+		{"Hello", true, 42, 23.45, map[string]string{"x": "1", "y": "2"}},
+		{"World", false, 43, 24.56, map[string]string{"x": "3", "y": "4"}},
+	}
+	v, err = RoundTrip(&b, Dialect{Separator: ';'})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(v, b) {
+		t.Errorf("invalid round trip got=%v expected=%v", v, b)
+	}
+
+	// encoder and decoder options are threaded through
+	j := []JSONTagged{{"x", 3, 1.5, ""}, {"y\tz", 0, 2.5, ""}}
+	v, err = RoundTrip(j,
+		Dialect{Separator: '\t'},
+		func(e *Encoder) { e.TagName("json") },
+		func(d *Decoder) { d.TagName("json") },
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(v, j) {
+		t.Errorf("invalid round trip got=%v expected=%v", v, j)
+	}
+	_, err = RoundTrip(j,
+		func(e *Encoder) { e.TagName("json") },
+		func(d *Decoder) { d.SkipUnknown(false) },
+	)
+	if err == nil {
+		t.Errorf("expected error for unknown fields with csv tags")
+	}
+
+	if _, err = RoundTrip(A1); err == nil {
+		t.Errorf("expected error for non-slice")
+	}
+	if _, err = RoundTrip(a, ';'); err == nil {
+		t.Errorf("expected error for invalid option")
+	}
+}
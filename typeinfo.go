@@ -17,9 +17,13 @@ package csv
 import (
 	"encoding"
 	"fmt"
+	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const tagName = "csv"
@@ -31,9 +35,15 @@ type typeInfo struct {
 
 // fieldInfo holds details for the xmp representation of a single field.
 type fieldInfo struct {
-	idx   []int
-	name  string
-	flags fieldFlags
+	idx    []int
+	name   string
+	flags  fieldFlags
+	format string
+	split  string
+	kv     string
+	tagged bool
+	order  int
+	goName string
 }
 
 func (f fieldInfo) String() string {
@@ -49,10 +59,20 @@ type fieldFlags int
 const (
 	fElement fieldFlags = 1 << iota
 	fAny
+	fOmitEmpty
+	fLine
+	fRecord
+	fInline
 	fMode = fElement | fAny
 )
 
-var tinfoMap = make(map[reflect.Type]*typeInfo)
+// tinfoKey identifies cached type info by type and struct tag name.
+type tinfoKey struct {
+	typ reflect.Type
+	tag string
+}
+
+var tinfoMap = make(map[tinfoKey]*typeInfo)
 var tinfoLock sync.RWMutex
 
 var (
@@ -60,13 +80,28 @@ var (
 	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
 	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
 	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	urlType             = reflect.TypeOf(url.URL{})
 )
 
+// ClearTypeCache removes all cached type details. The package caches details
+// of every struct type it encodes or decodes, so long-running programs which
+// generate types dynamically may use ClearTypeCache to reclaim memory. Type
+// details are recreated on demand.
+func ClearTypeCache() {
+	tinfoLock.Lock()
+	tinfoMap = make(map[tinfoKey]*typeInfo)
+	tinfoLock.Unlock()
+}
+
 // getTypeInfo returns the typeInfo structure with details necessary
-// for marshaling and unmarshaling typ.
-func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
+// for marshaling and unmarshaling typ using struct tags with name tag. Fields
+// are strictly ordered by their declaration in typ with fields of embedded
+// structs flattened in place.
+func getTypeInfo(typ reflect.Type, tag string) (*typeInfo, error) {
+	key := tinfoKey{typ, tag}
 	tinfoLock.RLock()
-	tinfo, ok := tinfoMap[typ]
+	tinfo, ok := tinfoMap[key]
 	tinfoLock.RUnlock()
 	if ok {
 		return tinfo, nil
@@ -78,7 +113,7 @@ func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 	n := typ.NumField()
 	for i := 0; i < n; i++ {
 		f := typ.Field(i)
-		if (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get(tagName) == "-" {
+		if (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get(tag) == "-" {
 			continue // Private field
 		}
 
@@ -89,13 +124,17 @@ func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 				t = t.Elem()
 			}
 			if t.Kind() == reflect.Struct {
-				inner, err := getTypeInfo(t)
+				inner, err := getTypeInfo(t, tag)
 				if err != nil {
 					return nil, err
 				}
+				prefix := embedPrefix(&f, tag)
 				for _, finfo := range inner.fields {
 					finfo.idx = append([]int{i}, finfo.idx...)
-					if err := addFieldInfo(typ, tinfo, &finfo); err != nil {
+					if prefix != "" {
+						finfo.name = prefix + "." + finfo.name
+					}
+					if err := addFieldInfo(typ, tinfo, &finfo, tag); err != nil {
 						return nil, err
 					}
 				}
@@ -103,25 +142,43 @@ func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 			}
 		}
 
-		finfo, err := structFieldInfo(typ, &f)
+		finfo, err := structFieldInfo(typ, &f, tag)
 		if err != nil {
 			return nil, err
 		}
 
 		// Add the field if it doesn't conflict with other fields.
-		if err := addFieldInfo(typ, tinfo, finfo); err != nil {
+		if err := addFieldInfo(typ, tinfo, finfo, tag); err != nil {
 			return nil, err
 		}
 	}
 	tinfoLock.Lock()
-	tinfoMap[typ] = tinfo
+	tinfoMap[key] = tinfo
 	tinfoLock.Unlock()
 	return tinfo, nil
 }
 
-// structFieldInfo builds and returns a fieldInfo for f.
-func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, error) {
-	finfo := &fieldInfo{idx: f.Index}
+// embedPrefix returns the prefix for promoted fields of embedded struct f when
+// its tag contains the `prefix` flag. The prefix is the tag name or the name of
+// the embedded type.
+func embedPrefix(f *reflect.StructField, tag string) string {
+	tokens := strings.Split(f.Tag.Get(tag), ",")
+	for _, flag := range tokens[1:] {
+		if flag != "prefix" {
+			continue
+		}
+		if tokens[0] != "" {
+			return tokens[0]
+		}
+		return f.Name
+	}
+	return ""
+}
+
+// structFieldInfo builds and returns a fieldInfo for f from the struct tag
+// with name tagName.
+func structFieldInfo(typ reflect.Type, f *reflect.StructField, tagName string) (*fieldInfo, error) {
+	finfo := &fieldInfo{idx: f.Index, goName: f.Name}
 	tag := f.Tag.Get(tagName)
 
 	// Parse flags.
@@ -131,9 +188,29 @@ func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, erro
 	} else {
 		tag = tokens[0]
 		for _, flag := range tokens[1:] {
-			switch flag {
-			case "any":
+			switch {
+			case flag == "any":
 				finfo.flags |= fAny
+			case flag == "omitempty":
+				finfo.flags |= fOmitEmpty
+			case flag == "line":
+				finfo.flags |= fLine
+			case flag == "record":
+				finfo.flags |= fRecord
+			case flag == "inline":
+				finfo.flags |= fInline
+			case strings.HasPrefix(flag, "format="):
+				finfo.format = strings.TrimPrefix(flag, "format=")
+			case strings.HasPrefix(flag, "split="):
+				finfo.split = strings.TrimPrefix(flag, "split=")
+			case strings.HasPrefix(flag, "kv="):
+				finfo.kv = strings.TrimPrefix(flag, "kv=")
+			case strings.HasPrefix(flag, "order="):
+				n, err := strconv.Atoi(strings.TrimPrefix(flag, "order="))
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("csv: %s field %q has invalid order %q", typ, f.Name, flag)
+				}
+				finfo.order = n
 			}
 		}
 
@@ -147,6 +224,7 @@ func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, erro
 
 	if tag != "" {
 		finfo.name = tag
+		finfo.tagged = true
 	} else {
 		// Use field name as default.
 		finfo.name = f.Name
@@ -155,7 +233,7 @@ func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, erro
 	return finfo, nil
 }
 
-func addFieldInfo(typ reflect.Type, tinfo *typeInfo, newf *fieldInfo) error {
+func addFieldInfo(typ reflect.Type, tinfo *typeInfo, newf *fieldInfo, tagName string) error {
 	var conflicts []int
 	// Find all conflicts.
 	for i := range tinfo.fields {
@@ -165,19 +243,51 @@ func addFieldInfo(typ reflect.Type, tinfo *typeInfo, newf *fieldInfo) error {
 		}
 	}
 
-	// Return the first error.
+	// Like in Go, a field at a shallower depth shadows promoted fields
+	// of embedded structs, so the new field may be hidden already.
+	for _, i := range conflicts {
+		if len(tinfo.fields[i].idx) < len(newf.idx) {
+			return nil
+		}
+	}
+
+	// Return the first error for conflicts at the same depth.
 	for _, i := range conflicts {
 		oldf := &tinfo.fields[i]
+		if len(oldf.idx) > len(newf.idx) {
+			continue
+		}
 		f1 := typ.FieldByIndex(oldf.idx)
 		f2 := typ.FieldByIndex(newf.idx)
 		return fmt.Errorf("csv: %s field %q with tag %q conflicts with field %q with tag %q", typ, f1.Name, f1.Tag.Get(tagName), f2.Name, f2.Tag.Get(tagName))
 	}
 
-	// Without conflicts, add the new field and return.
+	// Remove deeper fields shadowed by the new field.
+	for n := len(conflicts) - 1; n >= 0; n-- {
+		i := conflicts[n]
+		tinfo.fields = append(tinfo.fields[:i], tinfo.fields[i+1:]...)
+	}
+
+	// Add the new field and return.
 	tinfo.fields = append(tinfo.fields, *newf)
 	return nil
 }
 
+// orderedFields returns the type's fields sorted by their `order` tag value.
+// Fields without order follow in declaration order.
+func (tinfo *typeInfo) orderedFields() []fieldInfo {
+	fields := make([]fieldInfo, len(tinfo.fields))
+	copy(fields, tinfo.fields)
+	sort.SliceStable(fields, func(i, j int) bool {
+		oi, oj := fields[i].order, fields[j].order
+		if oi == 0 || oj == 0 {
+			return oj == 0 && oi > 0
+		}
+		return oi < oj
+	})
+	return fields
+}
+
 // value returns v's field value corresponding to finfo.
 // It's equivalent to v.FieldByIndex(finfo.idx), but initializes
 // and dereferences pointers as necessary.
@@ -198,6 +308,25 @@ func (finfo *fieldInfo) value(v reflect.Value) reflect.Value {
 	return v
 }
 
+// lookup returns the field described by finfo in struct v without allocating
+// nil embedded struct pointers. The result is invalid when the field is part
+// of such a nil embedded struct.
+func (finfo *fieldInfo) lookup(v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 {
+			t := v.Type()
+			if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+				if v.IsNil() {
+					return reflect.Value{}
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
 // Load value from interface, but only if the result will be
 // usefully addressable.
 func derefIndirect(v interface{}) reflect.Value {
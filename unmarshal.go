@@ -24,6 +24,7 @@
 // the number sign '#' (0x23). Records are separated by the newline character
 // '\n' (0x0A) and the final record may or may not be followed by a newline.
 // Carriage returns '\r' (0x0D) before newline characters are silently removed.
+// Quoted fields may contain newlines and span multiple lines of input.
 //
 // White space is considered part of a field. Leading or trailing whitespace
 // can optionally be trimmed when parsing a value. Fields may optionally be quoted
@@ -40,9 +41,18 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
 )
 
 const (
@@ -51,6 +61,27 @@ const (
 	Wrapper   = "\""
 )
 
+// DuplicatePolicy defines how the Decoder handles CSV fields whose name already
+// exists as key in a map that captures a record's fields, which happens with
+// duplicate header fields.
+type DuplicatePolicy int
+
+const (
+	OverwriteDuplicates DuplicatePolicy = iota // last value wins
+	RejectDuplicates                           // fail with a DecodeError
+	SuffixDuplicates                           // store as name_2, name_3, ...
+)
+
+// TrailingComma defines how the Decoder handles an empty last header field
+// caused by a trailing separator like in "a,b,c,".
+type TrailingComma int
+
+const (
+	KeepTrailingComma   TrailingComma = iota // keep an empty header field
+	DropTrailingComma                        // drop the empty header and record field
+	RejectTrailingComma                      // fail with a DecodeError
+)
+
 type DecodeError struct {
 	lineNo  int
 	fieldNo int
@@ -67,6 +98,30 @@ func (e *DecodeError) Error() string {
 	return fmt.Sprintf("csv: line %d: %v", e.lineNo, e.reason)
 }
 
+// Line returns the input line number where the error occurred.
+func (e *DecodeError) Line() int {
+	return e.lineNo
+}
+
+// Field returns the one-based number of the CSV field that caused the error
+// or zero when the error concerns the entire record.
+func (e *DecodeError) Field() int {
+	return e.fieldNo
+}
+
+// FieldName returns the header name of the CSV field that caused the error
+// or a hint describing the problem when the error concerns the entire record.
+func (e *DecodeError) FieldName() string {
+	return e.hint
+}
+
+// Unwrap returns the underlying error, for example a *strconv.NumError when a
+// numeric conversion failed, so it can be inspected with errors.Is and
+// errors.As.
+func (e *DecodeError) Unwrap() error {
+	return e.reason
+}
+
 // Unmarshaler is the interface implemented by types that can unmarshal a CSV record
 // from a slice of strings. The input is the scanned header array followed by all
 // fields for a record. Both slices are guaranteed to be of equal length.
@@ -88,23 +143,103 @@ type Unmarshaler interface {
 // passed to DecodeRecord() or the type of slice elements passed to Decode() assuming
 // records in the CSV file have the same order as attributes defined for the Go type.
 type Decoder struct {
+	r           io.Reader
 	s           *bufio.Scanner
+	buf         []byte
+	detectEnc   bool
 	sep         rune
 	comment     rune
 	readHeader  bool
 	skipUnknown bool
 	trim        bool
 	lineNo      int
+	scanNo      int
 	headerKeys  []string
+	footer      int
+	pending     []pendingLine
+	stats       map[string]ColumnStats
+	rename      map[string]string
+	matcher     func(header, tag string) bool
+	skip        int
+	limit       int
+	numRecords  int
+	trimTrail   bool
+	keepEmpty   bool
+	sepRe       *regexp.Regexp
+	fieldDecs   map[string]func(string) (interface{}, error)
+	numBools    bool
+	unknown     []string
+	repeated    []bool
+	maxRecord   int
+	lenient     bool
+	typeErrFn   func(lineNo int, field, value string, err error)
+	detectSep   bool
+	sepFound    bool
+	sectionFn   func()
+	sectBreak   bool
+	lazyQuotes  bool
+	requireIf   []requirement
+	trimHead    bool
+	inputs      []io.Reader
+	nextHead    bool
+	stopAt      string
+	stopped     bool
+	nullVals    map[string]bool
+	timeFmts    []string
+	omitEmbed   bool
+	goNames     bool
+	repeatHead  bool
+	preFn       func(line string) string
+	mergeNums   bool
+	underscore  bool
+	skipCols    map[int]bool
+	dupKeys     DuplicatePolicy
+	limitBytes  int64
+	sampleSep   int
+	peeked      []string
+	escapes     bool
+	tag         string
+	cutset      string
+	discCol     int
+	discValue   string
+	otherFn     func(kind string, fields []string) error
+	headComma   TrailingComma
+	dropTrail   bool
+	needData    bool
+}
+
+// requirement is a conditional requirement for a CSV field.
+type requirement struct {
+	field string
+	cond  func(record map[string]string) bool
+}
+
+// ColumnStats contains statistics about the values of a single CSV column.
+// Lengths are counted in runes after optional trimming.
+type ColumnStats struct {
+	Count  int // number of values
+	Empty  int // number of empty values
+	MinLen int // minimum value length
+	MaxLen int // maximum value length
+}
+
+// pendingLine is a line of input held back while looking for a footer.
+type pendingLine struct {
+	line   string
+	lineNo int
 }
 
 // NewDecoder returns a new decoder that reads from r.
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{
-		s:           bufio.NewScanner(r),
+		r:           r,
 		readHeader:  true,
 		trim:        true,
+		trimHead:    true,
 		skipUnknown: true,
+		timeFmts:    []string{time.RFC3339},
+		tag:         tagName,
+		discCol:     -1,
 		sep:         Separator,
 		comment:     Comment,
 		lineNo:      0,
@@ -112,6 +247,29 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// NewTSVDecoder returns a new decoder for tab separated values that reads from
+// r. Backslash escapes are enabled and field values are not trimmed, so tabs
+// and newlines escaped inside fields are preserved.
+func NewTSVDecoder(r io.Reader) *Decoder {
+	d := NewDecoder(r).Separator('\t').BackslashEscapes(true)
+	d.trim = false
+	return d
+}
+
+// NewMultiDecoder returns a new decoder that reads the concatenation of all
+// readers as a single stream of records. All inputs must share the same
+// schema. When a header is expected, it is read from the first input and the
+// header lines of subsequent inputs are checked against it and skipped. Line
+// numbers restart at each input.
+func NewMultiDecoder(readers ...io.Reader) *Decoder {
+	if len(readers) == 0 {
+		return NewDecoder(bytes.NewReader(nil))
+	}
+	d := NewDecoder(readers[0])
+	d.inputs = readers[1:]
+	return d
+}
+
 // Header controls if the decoder expects the input stream to contain header fields.
 func (d *Decoder) Header(h bool) *Decoder {
 	d.readHeader = h
@@ -124,6 +282,34 @@ func (d *Decoder) Separator(r rune) *Decoder {
 	return d
 }
 
+// LazyQuotes controls if the Decoder accepts a quote appearing inside an
+// unquoted field, such as in `5" screen`, and data following the closing quote
+// of a quoted field, such as in `"abc"def`. When true, the quote is kept as
+// literal character and data after a closing quote is appended to the field.
+// Otherwise such a field is rejected as parse error.
+func (d *Decoder) LazyQuotes(t bool) *Decoder {
+	d.lazyQuotes = t
+	return d
+}
+
+// BackslashEscapes controls if the Decoder unescapes backslash sequences inside
+// fields as used by tab separated values. The sequences \t, \n and \r are
+// replaced by tab, newline and carriage return. A backslash followed by any
+// other character, including the separator, a quote and a second backslash,
+// yields that character literally.
+func (d *Decoder) BackslashEscapes(t bool) *Decoder {
+	d.escapes = t
+	return d
+}
+
+// SeparatorRegexp sets a regular expression re that matches field separators.
+// When set, re is used instead of the separator rune and quoted fields are not
+// recognized.
+func (d *Decoder) SeparatorRegexp(re *regexp.Regexp) *Decoder {
+	d.sepRe = re
+	return d
+}
+
 // Comment sets rune c as comment line identifier. Comments must start with rune c
 // as first character to be skipped.
 func (d *Decoder) Comment(c rune) *Decoder {
@@ -132,8 +318,33 @@ func (d *Decoder) Comment(c rune) *Decoder {
 }
 
 // Trim controls if the Decoder will trim whitespace surrounding header fields
-// and records before processing them.
+// and records before processing them. It is a shortcut for setting both
+// TrimHeader and TrimFields.
 func (d *Decoder) Trim(t bool) *Decoder {
+	d.trim = t
+	d.trimHead = t
+	return d
+}
+
+// TrimCutset sets the characters removed from both ends of header and record
+// fields when trimming is enabled, such as "*" for padded fields. An empty
+// cutset, which is the default, removes whitespace.
+func (d *Decoder) TrimCutset(cutset string) *Decoder {
+	d.cutset = cutset
+	return d
+}
+
+// TrimHeader controls if the Decoder will trim whitespace surrounding header
+// fields.
+func (d *Decoder) TrimHeader(t bool) *Decoder {
+	d.trimHead = t
+	return d
+}
+
+// TrimFields controls if the Decoder will trim whitespace surrounding record
+// fields before processing them. When false, whitespace is preserved in string
+// values.
+func (d *Decoder) TrimFields(t bool) *Decoder {
 	d.trim = t
 	return d
 }
@@ -146,13 +357,382 @@ func (d *Decoder) SkipUnknown(t bool) *Decoder {
 	return d
 }
 
+// RepeatedHeader controls if Decode skips records that repeat the header, as
+// found in files that concatenate multiple exports with identical headers.
+func (d *Decoder) RepeatedHeader(t bool) *Decoder {
+	d.repeatHead = t
+	return d
+}
+
+// SkipFooter sets the number n of trailing non-empty and non-commented lines
+// Decode will ignore at the end of input. This is useful for files that append
+// a summary footer after their records. Since the input is processed as a
+// stream, the last n lines are held back until more input or EOF is seen.
+func (d *Decoder) SkipFooter(n int) *Decoder {
+	d.footer = n
+	return d
+}
+
+// Rename sets a map m that translates CSV header field names as found in the
+// input to names used for matching struct tags. Header fields without entry
+// in m are used as is.
+func (d *Decoder) Rename(m map[string]string) *Decoder {
+	d.rename = m
+	return d
+}
+
+// MatchGoNames controls if the Decoder matches CSV header fields against Go
+// struct field names instead of names defined in struct tags.
+func (d *Decoder) MatchGoNames(t bool) *Decoder {
+	d.goNames = t
+	return d
+}
+
+// HeaderMatcher sets a function fn that decides whether a CSV header field
+// name matches the name of a struct field as defined by its struct tag. By
+// default names must be equal.
+func (d *Decoder) HeaderMatcher(fn func(header, tag string) bool) *Decoder {
+	d.matcher = fn
+	return d
+}
+
+// HeaderTrailingComma sets how the Decoder handles a header line ending in a
+// separator, which results in an empty last header field. By default the empty
+// field is kept. When dropped, an empty last field of each record is dropped
+// as well, so records written with the same trailing separator still match the
+// header.
+func (d *Decoder) HeaderTrailingComma(mode TrailingComma) *Decoder {
+	d.headComma = mode
+	return d
+}
+
+// TrimTrailingSeparator controls if the Decoder removes a single trailing
+// separator from the header and every record before splitting it into fields.
+func (d *Decoder) TrimTrailingSeparator(t bool) *Decoder {
+	d.trimTrail = t
+	return d
+}
+
+// KeepEmptyLines controls if the Decoder treats empty lines following the
+// header as records with all fields empty instead of skipping them. ReadLine
+// returns such lines as a sequence of empty fields.
+func (d *Decoder) KeepEmptyLines(t bool) *Decoder {
+	d.keepEmpty = t
+	return d
+}
+
+// StopAt sets a marker line at which the Decoder stops reading input as if
+// EOF was reached. The marker must match a complete line of input. Any content
+// following the marker is ignored.
+func (d *Decoder) StopAt(marker string) *Decoder {
+	d.stopAt = marker
+	return d
+}
+
+// MergeNumericSplits controls if the Decoder tries to recover records where
+// an unquoted number contains the field separator as grouping character, such
+// as 1,234 in comma separated files. When a record has more fields than the
+// header, adjacent fields that look like digit groups are merged into numeric
+// struct fields until the number of fields matches. This is a heuristic and
+// may still fail for ambiguous records.
+func (d *Decoder) MergeNumericSplits(t bool) *Decoder {
+	d.mergeNums = t
+	return d
+}
+
+// PreprocessLine registers a function fn that may rewrite each non-empty and
+// non-commented line of input, including the header, before it is parsed.
+// This is useful to repair known defects of malformed input. Lines for which
+// fn returns an empty string are skipped.
+func (d *Decoder) PreprocessLine(fn func(line string) string) *Decoder {
+	d.preFn = fn
+	return d
+}
+
+// Skip sets the number n of records Decode skips after the header. Skipped
+// records are not decoded.
+func (d *Decoder) Skip(n int) *Decoder {
+	d.skip = n
+	return d
+}
+
+// Limit sets the maximum number n of records Decode will decode. When n is
+// zero, all records are decoded. Together with Skip, Limit allows to read a
+// range of records from input.
+func (d *Decoder) Limit(n int) *Decoder {
+	d.limit = n
+	return d
+}
+
+// RequireIf registers a condition cond under which the CSV field with header
+// name field must not be empty. cond is called for each record with a map of
+// header field names to (optionally trimmed) values. A record for which cond
+// returns true while field is empty or missing fails to decode.
+func (d *Decoder) RequireIf(field string, cond func(record map[string]string) bool) *Decoder {
+	d.requireIf = append(d.requireIf, requirement{field, cond})
+	return d
+}
+
+// NullValue sets strings that represent missing values in the input, such as
+// "NULL" or "0000-00-00". Fields containing one of these values are decoded
+// like empty fields and keep their zero value.
+func (d *Decoder) NullValue(values ...string) *Decoder {
+	d.nullVals = make(map[string]bool, len(values))
+	for _, v := range values {
+		d.nullVals[v] = true
+	}
+	return d
+}
+
+// TimeFormat sets the layout used to parse time.Time fields that don't define
+// a layout with "format=" in their struct tag. The default is time.RFC3339.
+// Empty time fields always decode as zero time.
+func (d *Decoder) TimeFormat(layout string) *Decoder {
+	d.timeFmts = []string{layout}
+	return d
+}
+
+// TimeFormats sets a list of layouts used to parse time.Time fields that don't
+// define a layout with "format=" in their struct tag. Layouts are tried in
+// order until one parses the value, so fields may mix dates, times and full
+// timestamps. When no layout matches, the error for the first layout is
+// returned.
+func (d *Decoder) TimeFormats(layouts ...string) *Decoder {
+	if len(layouts) > 0 {
+		d.timeFmts = layouts
+	}
+	return d
+}
+
+// OmitEmptyEmbedded controls if the Decoder leaves embedded struct pointers nil
+// when all CSV fields mapped to the embedded struct are empty. By default,
+// embedded struct pointers are always allocated.
+func (d *Decoder) OmitEmptyEmbedded(t bool) *Decoder {
+	d.omitEmbed = t
+	return d
+}
+
+// FieldDecoder registers a function fn that decodes values of the CSV field
+// with header name name. The value returned by fn is assigned to the struct
+// field mapped to name and must be assignable or convertible to its type.
+// Field decoders take precedence over all other conversions.
+func (d *Decoder) FieldDecoder(name string, fn func(s string) (interface{}, error)) *Decoder {
+	if d.fieldDecs == nil {
+		d.fieldDecs = make(map[string]func(string) (interface{}, error))
+	}
+	d.fieldDecs[name] = fn
+	return d
+}
+
+// TagName sets the name of the struct tag used to map CSV fields to struct
+// fields. The default is "csv". Other tags using the same syntax, such as
+// "json", may be used to avoid duplicating tags.
+func (d *Decoder) TagName(name string) *Decoder {
+	d.tag = name
+	return d
+}
+
+// DiscriminatorColumn sets the zero-based index of a column that identifies the
+// type of each record in files mixing different kinds of records, such as
+// header, data and trailer lines. Only records whose discriminator equals
+// dataValue are decoded. Other records are skipped or passed to the function
+// registered with OnOtherRecord. The header line is not checked.
+func (d *Decoder) DiscriminatorColumn(index int, dataValue string) *Decoder {
+	d.discCol = index
+	d.discValue = dataValue
+	return d
+}
+
+// OnOtherRecord registers a function fn that is called with the discriminator
+// value and the fields of every record skipped because of DiscriminatorColumn.
+// Decoding stops when fn returns an error.
+func (d *Decoder) OnOtherRecord(fn func(kind string, fields []string) error) *Decoder {
+	d.otherFn = fn
+	return d
+}
+
+// RequireData controls if decoding fails when the input contains no records,
+// which may indicate a truncated or missing file. Inputs without a header fail
+// with a different error than inputs containing only a header.
+func (d *Decoder) RequireData(t bool) *Decoder {
+	d.needData = t
+	return d
+}
+
+// SkipColumns marks CSV columns at zero-based indices as ignored. When the
+// header is derived from the type definition, skipped columns are left out
+// of positional assignment, so struct fields map to the remaining columns in
+// order. This allows to decode headerless files with extra columns between
+// mapped ones.
+func (d *Decoder) SkipColumns(indices ...int) *Decoder {
+	if d.skipCols == nil {
+		d.skipCols = make(map[int]bool)
+	}
+	for _, i := range indices {
+		d.skipCols[i] = true
+	}
+	return d
+}
+
+// DuplicateKeys sets the policy for CSV fields that share a name when they are
+// captured into a map, for example an `any` map receiving extra columns with
+// duplicate header names. By default later values overwrite earlier ones.
+func (d *Decoder) DuplicateKeys(p DuplicatePolicy) *Decoder {
+	d.dupKeys = p
+	return d
+}
+
+// AllowUnderscores controls if the Decoder accepts underscores as digit
+// separators in numeric fields, as in Go literals like 1_000_000.
+func (d *Decoder) AllowUnderscores(t bool) *Decoder {
+	d.underscore = t
+	return d
+}
+
+// NumericBools controls if the Decoder accepts arbitrary integer numbers for
+// boolean fields, where any non-zero number is true.
+func (d *Decoder) NumericBools(t bool) *Decoder {
+	d.numBools = t
+	return d
+}
+
+// LenientTypes controls if the Decoder ignores values that cannot be converted
+// into numeric, boolean or enum fields. When true, such fields are left at their zero
+// value instead of failing the record. See TypeErrorFunc for how to observe
+// ignored values.
+func (d *Decoder) LenientTypes(t bool) *Decoder {
+	d.lenient = t
+	return d
+}
+
+// TypeErrorFunc registers a function fn that is called with the line number,
+// header field name, value and conversion error for each value ignored under
+// LenientTypes.
+func (d *Decoder) TypeErrorFunc(fn func(lineNo int, field, value string, err error)) *Decoder {
+	d.typeErrFn = fn
+	return d
+}
+
+// HeaderKeys returns the header fields used to map CSV records after they have
+// been read from input or derived from a type. This may be used to write
+// records with the same header, for example when decoding into maps.
+func (d *Decoder) HeaderKeys() []string {
+	return append([]string(nil), d.headerKeys...)
+}
+
+// UnknownColumns returns the names of header fields that cannot be mapped to
+// any field of the decoded type. The result is available after the first
+// record has been decoded into a struct and is nil before.
+func (d *Decoder) UnknownColumns() []string {
+	return d.unknown
+}
+
+// CollectStats controls if the Decoder collects per-column statistics while
+// decoding records. Statistics are available from Stats.
+func (d *Decoder) CollectStats(t bool) *Decoder {
+	if t {
+		d.stats = make(map[string]ColumnStats)
+	} else {
+		d.stats = nil
+	}
+	return d
+}
+
+// Stats returns per-column statistics for all records decoded so far keyed by
+// header field name or nil when statistics collection is disabled.
+func (d *Decoder) Stats() map[string]ColumnStats {
+	return d.stats
+}
+
+// MaxRecordBytes sets the maximum size n in bytes of a single record. Since
+// quoted fields may span multiple lines, an unterminated quote could otherwise
+// cause the Decoder to collect all remaining input into one record. ReadLine
+// returns an error when a record exceeds n bytes. Zero means no limit.
+func (d *Decoder) MaxRecordBytes(n int) *Decoder {
+	d.maxRecord = n
+	return d
+}
+
+// LimitBytes limits the input read by the Decoder to n bytes, so decoding stops
+// after n bytes regardless of content. This allows to decode CSV data embedded
+// in a larger stream, for example in framed protocols, without consuming input
+// beyond the frame. Bytes are counted before encoding detection. The limit
+// applies to each input of a multi decoder and must be set before reading.
+func (d *Decoder) LimitBytes(n int64) *Decoder {
+	d.limitBytes = n
+	return d
+}
+
 // Buffer sets a buffer buf to be used by the underlying bufio.Scanner for reading
 // from io.Reader r.
 func (d *Decoder) Buffer(buf []byte) *Decoder {
-	d.s.Buffer(buf, cap(buf))
+	d.buf = buf
+	if d.s != nil {
+		d.s.Buffer(buf, cap(buf))
+	}
+	return d
+}
+
+// DetectSeparator controls if the Decoder detects the field separator from the
+// first line of input. Candidates are comma, semicolon, tab and pipe; the one
+// occurring most often outside quoted fields wins. When no candidate is found,
+// the configured separator is kept. The separator is detected again on the
+// first line following a blank line to support files that concatenate
+// sections with different separators.
+func (d *Decoder) DetectSeparator(t bool) *Decoder {
+	d.detectSep = t
+	return d
+}
+
+// DetectSampleLines sets the number of lines n sampled by DetectSeparator. With
+// more than one line, the candidate yielding the same non-zero number of
+// separators on every sampled line wins, so stray separators in data rows do
+// not override the header's separator. Sampling stops at the end of the
+// current section. When no candidate is consistent, the first line decides.
+// The default is 1.
+func (d *Decoder) DetectSampleLines(n int) *Decoder {
+	d.sampleSep = n
+	return d
+}
+
+// OnSectionBreak registers a function fn that is called by ReadLine before
+// returning the first line following one or more blank lines. This may be used
+// to process files that concatenate multiple CSV sections, for example to
+// decode the first line of a new section as header.
+func (d *Decoder) OnSectionBreak(fn func()) *Decoder {
+	d.sectionFn = fn
+	return d
+}
+
+// AutoDetectEncoding controls if the Decoder inspects the first bytes of input
+// for a byte order mark (BOM). When enabled, a UTF-8 BOM is removed and UTF-16
+// or UTF-32 input with BOM is transparently converted to UTF-8. Must be set
+// before reading any input.
+func (d *Decoder) AutoDetectEncoding(t bool) *Decoder {
+	d.detectEnc = t
 	return d
 }
 
+// scanner returns the bufio.Scanner used to read lines of input and creates
+// it on first use.
+func (d *Decoder) scanner() *bufio.Scanner {
+	if d.s != nil {
+		return d.s
+	}
+	r := d.r
+	if d.limitBytes > 0 {
+		r = io.LimitReader(r, d.limitBytes)
+	}
+	if d.detectEnc {
+		r = newEncodingReader(r)
+	}
+	d.s = bufio.NewScanner(r)
+	if d.buf != nil {
+		d.s.Buffer(d.buf, cap(d.buf))
+	}
+	return d.s
+}
+
 // Unmarshal parses CSV encoded data and stores the result in the slice v.
 //
 // Unmarshal allocates new slice elements for each CSV record encountered
@@ -170,8 +750,33 @@ func (d *Decoder) Buffer(buf []byte) *Decoder {
 //     // Field is used to store all unmapped CSV fields.
 //     Field map[string]string `csv:",any"`
 //
+//     // Field is parsed using the time layout following "format=".
+//     Field time.Time `csv:"name,format=2006-01-02"`
+//
+//     // Field is split into slice elements at the separator following
+//     // "split=". Pointers to slices are allocated as needed.
+//     Field *[]int `csv:"name,split=;"`
+//
+//     // Key-value pairs like "a:1;b:2" are decoded into the fields of
+//     // a nested struct. "kv=" is followed by the pair separator and an
+//     // optional key-value separator, which defaults to a colon.
+//     Field KV `csv:"name,kv=;:"`
+//
+//     // Field receives the line number of the record in the input.
+//     Field int `csv:",line"`
+//
+//     // Field receives all fields of the record as read from input.
+//     Field []string `csv:",record"`
+//
+//     // Fields of the embedded struct are named "home.<name>". Without
+//     // a tag name the embedded type name is used as prefix.
+//     Address `csv:"home,prefix"`
+//
 // A special flag 'any' can be used on a map or any other field type implementing
-// TextUnmarshaler interface to capture all unmapped CSV fields of a record.
+// TextUnmarshaler interface to capture all unmapped CSV fields of a record. When
+// used on a string slice, values of all unmapped CSV fields are appended in
+// header order. A struct containing only an 'any' map receives all CSV fields of
+// a record, which requires the input to have a header.
 //
 func Unmarshal(data []byte, v interface{}) error {
 	return NewDecoder(bytes.NewReader(data)).Decode(v)
@@ -199,274 +804,1484 @@ func Unmarshal(data []byte, v interface{}) error {
 //          // process the next record here
 //      }
 func (d *Decoder) ReadLine() (string, error) {
-	for d.s.Scan() {
-		line := d.s.Text()
-		d.lineNo++
+	if err := d.checkConfig(); err != nil {
+		return "", err
+	}
+	for {
+		line, err := d.scanLine()
+		if err != nil {
+			return "", err
+		}
+		if line == "" {
+			// continue with the next input at EOF
+			if len(d.inputs) == 0 || d.stopped {
+				return "", nil
+			}
+			d.r, d.inputs = d.inputs[0], d.inputs[1:]
+			d.s, d.scanNo = nil, 0
+			d.nextHead = d.readHeader && len(d.headerKeys) > 0
+			continue
+		}
+		if d.nextHead {
+			// skip the repeated header of subsequent inputs
+			d.nextHead = false
+			keys, err := d.parseHeader(line)
+			if err != nil {
+				return "", err
+			}
+			if !equalFields(keys, d.headerKeys) {
+				return "", &DecodeError{d.lineNo, 0, "header does not match first input", nil}
+			}
+			continue
+		}
+		return line, nil
+	}
+}
+
+// scanLine returns the next non-empty and non-commented line from the current
+// input or an empty string at EOF.
+func (d *Decoder) scanLine() (string, error) {
+	for !d.stopped {
+		line, ok := d.scan()
+		if !ok {
+			break
+		}
+		d.scanNo++
+		d.lineNo = d.scanNo
+		if d.stopAt != "" && line == d.stopAt {
+			d.stopped = true
+			break
+		}
 		if len(line) == 0 {
+			// a blank line after content ends the current section
+			d.sectBreak = d.sepFound
+			if d.keepEmpty && len(d.headerKeys) > 0 {
+				return d.emptyRecord(), nil
+			}
 			continue
 		}
-		if strings.HasPrefix(line, string(d.comment)) {
+		if d.isComment(line) {
 			continue
 		}
+		if d.sectBreak {
+			d.sectBreak = false
+			if d.sectionFn != nil {
+				d.sectionFn()
+			}
+			if d.detectSep {
+				d.sepFound = false
+			}
+		}
+		if !d.sepFound {
+			if d.detectSep {
+				if r, ok := detectSeparator(d.sample(line)); ok {
+					d.sep = r
+				}
+			}
+			d.sepFound = true
+		}
+		// join lines while a quoted field spans across newlines
+		var st quoteState
+		for d.inQuote(line, &st) && d.checkSize(line) {
+			next, ok := d.scan()
+			if !ok {
+				break
+			}
+			d.scanNo++
+			line += "\n" + next
+		}
+		if !d.checkSize(line) {
+			return "", &DecodeError{d.lineNo, 0, fmt.Sprintf("record exceeds %d bytes", d.maxRecord), nil}
+		}
+		if d.preFn != nil {
+			if line = d.preFn(line); line == "" {
+				continue
+			}
+		}
 		return line, nil
 	}
-	if err := d.s.Err(); err != nil {
+	if err := d.scanner().Err(); err != nil {
 		return "", fmt.Errorf("csv: read failed: %v", err)
 	}
 	return "", nil
 }
 
-// Decode reads CSV records from the input and stores their decoded values in the slice
-// pointed to by v.
-//
-// See the documentation for Unmarshal for details about the conversion of CSV records
-// into a Go value.
-func (d *Decoder) Decode(v interface{}) error {
-	val := reflect.ValueOf(v)
-	if val.Kind() != reflect.Ptr {
-		return fmt.Errorf("csv: non-pointer passed to Unmarshal")
+// scan returns the next raw line of the current input, either from lines
+// read ahead for separator detection or from the scanner.
+func (d *Decoder) scan() (string, bool) {
+	if len(d.peeked) > 0 {
+		line := d.peeked[0]
+		d.peeked = d.peeked[1:]
+		return line, true
 	}
-
-	val = reflect.Indirect(val)
-	if val.Kind() != reflect.Slice {
-		return fmt.Errorf("csv: non-slice passed to Unmarshal")
+	s := d.scanner()
+	if !s.Scan() {
+		return "", false
 	}
+	return s.Text(), true
+}
 
-	// prepare header from type info
-	if !d.readHeader {
-		tinfo, err := getTypeInfo(indirectType(val.Type().Elem()))
-		if err != nil {
-			return fmt.Errorf("csv: %v", err)
+// sample returns line followed by up to DetectSampleLines-1 non-comment lines
+// of the same section, which are read ahead and kept for scanning.
+func (d *Decoder) sample(line string) []string {
+	lines := []string{line}
+	if !d.detectSep || d.sampleSep <= 1 {
+		return lines
+	}
+	s := d.scanner()
+	for len(d.peeked) < d.sampleSep-1 && s.Scan() {
+		d.peeked = append(d.peeked, s.Text())
+	}
+	for _, v := range d.peeked {
+		if len(lines) == d.sampleSep || v == "" || v == d.stopAt {
+			break
 		}
-		for _, finfo := range tinfo.fields {
-			if finfo.flags&fAny == 0 {
-				d.headerKeys = append(d.headerKeys, finfo.name)
-			}
+		if !d.isComment(v) {
+			lines = append(lines, v)
 		}
 	}
+	return lines
+}
 
-	// everything happens driven by a bufio.Scanner
-	for d.s.Scan() {
-		line := d.s.Text()
-		d.lineNo++
-
-		// skip empty lines
-		if len(line) == 0 {
-			continue
-		}
+// separators is the list of candidate separators for detection.
+var separators = []rune{',', ';', '\t', '|'}
 
-		// skip comments
-		if strings.HasPrefix(line, string(d.comment)) {
+// detectSeparator returns the candidate separator that occurs the same
+// non-zero number of times outside quoted fields on all lines, preferring the
+// one occurring most often. When no candidate is consistent, the candidate
+// occurring most often on the first line is returned.
+func detectSeparator(lines []string) (rune, bool) {
+	counts := make([]map[rune]int, len(lines))
+	for i, line := range lines {
+		counts[i] = countRunes(line)
+	}
+	var best rune
+	for _, r := range separators {
+		if counts[0][r] <= counts[0][best] {
 			continue
 		}
-
-		// process header when not disabled
-		if len(d.headerKeys) == 0 && d.readHeader {
-			if _, err := d.DecodeHeader(line); err != nil {
-				return err
+		consistent := true
+		for _, c := range counts[1:] {
+			if c[r] != counts[0][r] {
+				consistent = false
+				break
 			}
-			continue
 		}
-
-		// process lines
-		e := reflect.New(val.Type().Elem())
-		if err := d.unmarshal(e.Elem(), line); err != nil {
-			return err
+		if consistent {
+			best = r
 		}
-
-		// append to slice
-		val.Set(reflect.Append(val, e.Elem()))
 	}
-	if err := d.s.Err(); err != nil {
-		return fmt.Errorf("csv: read failed: %v", err)
+	if best != 0 {
+		return best, true
 	}
-
-	return nil
+	for _, r := range separators {
+		if counts[0][r] > counts[0][best] {
+			best = r
+		}
+	}
+	return best, best != 0
 }
 
-// DecodeHeader reads CSV head fields from line and stores them as internal
-// Decoder state required to map CSV records later on.
-func (d *Decoder) DecodeHeader(line string) ([]string, error) {
-	d.headerKeys = strings.Split(line, string(d.sep))
+// countRunes counts the runes of line outside quoted fields.
+func countRunes(line string) map[rune]int {
+	var (
+		counts = make(map[rune]int)
+		quoted bool
+	)
+	for _, r := range line {
+		if string(r) == Wrapper {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+	return counts
+}
+
+// checkConfig returns an error when separator, comment and quote characters
+// are not mutually distinct.
+func (d *Decoder) checkConfig() error {
+	wrapper := rune(Wrapper[0])
+	switch {
+	case d.sepRe == nil && d.sep == d.comment:
+		return fmt.Errorf("csv: separator and comment character must differ (%q)", d.sep)
+	case d.sepRe == nil && d.sep == wrapper:
+		return fmt.Errorf("csv: separator must not be the quote character (%q)", d.sep)
+	case d.comment == wrapper:
+		return fmt.Errorf("csv: comment must not be the quote character (%q)", d.comment)
+	}
+	return nil
+}
+
+// checkSize returns false when line exceeds the maximum record size.
+func (d *Decoder) checkSize(line string) bool {
+	return d.maxRecord <= 0 || len(line) <= d.maxRecord
+}
+
+// quoteState keeps the quoting state of a record between calls to inQuote.
+type quoteState struct {
+	quoted bool // inside a quoted section
+	opened bool // field started with a quote
+	start  int  // offset of the current field
+	pos    int  // offset where scanning continues
+}
+
+// inQuote returns true when line ends inside a quoted field, following the
+// same quoting rules as split. Scanning continues at the offset stored in st,
+// so a record growing by continuation lines is scanned only once.
+func (d *Decoder) inQuote(line string, st *quoteState) bool {
+	if d.sepRe != nil || !strings.Contains(line[st.pos:], Wrapper) && !st.quoted {
+		return false
+	}
+	wrapper := rune(Wrapper[0])
+	for i, w := st.pos, 0; i < len(line); i += w {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		w = size
+		switch {
+		case d.escapes && r == '\\' && i+w == len(line):
+			// continue at the backslash which may escape the newline
+			st.pos = i
+			return st.quoted
+		case d.escapes && r == '\\':
+			// skip the escaped character
+			_, n := utf8.DecodeRuneInString(line[i+w:])
+			w += n
+		case st.quoted && r == wrapper:
+			// escaped quote or end of quoted section
+			if strings.HasPrefix(line[i+w:], Wrapper) {
+				w += len(Wrapper)
+			} else {
+				st.quoted = false
+			}
+		case st.quoted:
+		case r == d.sep:
+			st.start, st.opened = i+w, false
+		case r == wrapper && !st.opened && (i == st.start || d.trim && d.trimValue(line[st.start:i]) == ""):
+			st.quoted, st.opened = true, true
+		}
+	}
+	st.pos = len(line)
+	return st.quoted
+}
+
+// emptyRecord returns a line containing one empty field per header field.
+func (d *Decoder) emptyRecord() string {
+	line := Wrapper + Wrapper + strings.Repeat(string(d.sep), len(d.headerKeys)-1)
+	if d.trimTrail {
+		line += string(d.sep)
+	}
+	return line
+}
+
+// isComment returns true when line starts with the comment rune. Since the
+// comment rune must be the very first character, a line starting with a quoted
+// field is never a comment even if the field's content starts with the comment
+// rune.
+func (d *Decoder) isComment(line string) bool {
+	if strings.HasPrefix(line, Wrapper) {
+		return false
+	}
+	return strings.HasPrefix(line, string(d.comment))
+}
+
+// Decode reads CSV records from the input and stores their decoded values in the slice
+// pointed to by v.
+//
+// See the documentation for Unmarshal for details about the conversion of CSV records
+// into a Go value.
+func (d *Decoder) Decode(v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv: non-pointer passed to Unmarshal")
+	}
+
+	val = reflect.Indirect(val)
+	if val.Kind() != reflect.Slice {
+		return fmt.Errorf("csv: non-slice passed to Unmarshal")
+	}
+
+	return d.decode(val.Type().Elem(), func(e reflect.Value) error {
+		// append to slice
+		val.Set(reflect.Append(val, e))
+		return nil
+	})
+}
+
+// DecodeAs reads CSV records from the input like Decode, but allocates values
+// of type elemType for each record instead of the slice's element type. This
+// allows to decode into slices of interfaces such as []interface{}. Values of
+// elemType must be assignable to the element type of the slice pointed to by v.
+func (d *Decoder) DecodeAs(v interface{}, elemType reflect.Type) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv: non-pointer passed to DecodeAs")
+	}
+
+	val = reflect.Indirect(val)
+	if val.Kind() != reflect.Slice {
+		return fmt.Errorf("csv: non-slice passed to DecodeAs")
+	}
+	if elemType == nil || !elemType.AssignableTo(val.Type().Elem()) {
+		return fmt.Errorf("csv: type %v is not assignable to %s", elemType, val.Type().Elem())
+	}
+
+	return d.decode(elemType, func(e reflect.Value) error {
+		val.Set(reflect.Append(val, e))
+		return nil
+	})
+}
+
+// Check reads and decodes all CSV records from the input like Decode, but
+// discards decoded values instead of storing them. It returns the first error
+// encountered and may be used to validate a file before actually importing it.
+//
+// typeHint defines the type of records and may be a struct, a pointer to
+// a struct or a slice of such types.
+func (d *Decoder) Check(typeHint interface{}) error {
+	typ := reflect.TypeOf(typeHint)
+	if typ == nil {
+		return fmt.Errorf("csv: nil type hint passed to Check")
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	return d.decode(typ, func(reflect.Value) error {
+		return nil
+	})
+}
+
+// Each reads CSV records from the input, decodes each record into v and calls
+// fn after every record. v must be a non-nil pointer and is reused for all
+// records, so its content is reset before each record is decoded and fn must
+// copy values it wants to keep. Decoding stops when fn returns an error, which
+// is then returned by Each. At the end of input Each returns nil.
+func (d *Decoder) Each(v interface{}, fn func() error) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return fmt.Errorf("csv: non-pointer or nil pointer passed to Each")
+	}
+	val = val.Elem()
+	typ := val.Type()
+
+	// prepare header from type info
+	if !d.readHeader {
+		if err := d.typeHeader(typ); err != nil {
+			return err
+		}
+	}
+
+	return d.readRecords(func(line string) error {
+		val.Set(reflect.Zero(typ))
+		if err := d.unmarshal(val, line); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// decode reads all remaining records from the input, decodes each into a newly
+// allocated value of type typ and passes the result to fn.
+func (d *Decoder) decode(typ reflect.Type, fn func(reflect.Value) error) error {
+	// prepare header from type info
+	if !d.readHeader {
+		if err := d.typeHeader(typ); err != nil {
+			return err
+		}
+	}
+
+	return d.readRecords(func(line string) error {
+		e := reflect.New(typ)
+		if err := d.unmarshal(e.Elem(), line); err != nil {
+			return err
+		}
+		return fn(e.Elem())
+	})
+}
+
+// readRecords reads all remaining record lines from the input and passes them
+// to fn. It processes the header when expected and handles footer, skip and
+// limit settings.
+func (d *Decoder) readRecords(fn func(line string) error) error {
+	var n int
+	for {
+		// stop when limit is reached
+		if d.limit > 0 && d.numRecords >= d.skip+d.limit {
+			return nil
+		}
+
+		line, err := d.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		// stop at EOF
+		if line == "" {
+			return d.checkData(n)
+		}
+
+		// process header when not disabled
+		if len(d.headerKeys) == 0 && d.readHeader {
+			if _, err := d.DecodeHeader(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// skip repeated header lines
+		if d.repeatHead && d.isHeader(line) {
+			continue
+		}
+
+		// skip records of other kinds
+		if d.discCol >= 0 {
+			if ok, err := d.isDataRecord(line); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+		}
+
+		// hold back potential footer lines
+		if d.footer > 0 {
+			d.pending = append(d.pending, pendingLine{line, d.lineNo})
+			if len(d.pending) <= d.footer {
+				continue
+			}
+			line, d.lineNo = d.pending[0].line, d.pending[0].lineNo
+			d.pending = d.pending[1:]
+		}
+
+		// skip records before offset
+		d.numRecords++
+		if d.numRecords <= d.skip {
+			continue
+		}
+
+		// process lines
+		n++
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+// checkData returns an error when data is required but the input contained
+// no header or n is zero.
+func (d *Decoder) checkData(n int) error {
+	if !d.needData || n > 0 {
+		return nil
+	}
+	if d.readHeader && len(d.headerKeys) == 0 {
+		return fmt.Errorf("csv: empty input")
+	}
+	return fmt.Errorf("csv: no records found")
+}
+
+// isDataRecord returns true when the discriminator column of line contains
+// the data value. Other records are passed to the OnOtherRecord function.
+func (d *Decoder) isDataRecord(line string) (bool, error) {
+	tokens, err := d.split(line)
+	if err != nil {
+		return false, &DecodeError{d.lineNo, 0, "", err}
+	}
+	var kind string
+	if d.discCol < len(tokens) {
+		kind = d.trimValue(tokens[d.discCol])
+	}
+	if kind == d.discValue {
+		return true, nil
+	}
+	if d.otherFn != nil {
+		if err := d.otherFn(kind, tokens); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// DecodeRows reads all remaining CSV records from the input and returns their
+// fields as string slices. When the Decoder expects a header, the first line
+// is processed as header and is not part of the result. Otherwise all lines
+// are returned as records.
+func (d *Decoder) DecodeRows() ([][]string, error) {
+	rows := make([][]string, 0)
+	err := d.readRecords(func(line string) error {
+		tokens, err := d.split(line)
+		if err != nil {
+			return &DecodeError{d.lineNo, 0, "", err}
+		}
+		if d.trim {
+			for i, v := range tokens {
+				tokens[i] = d.trimValue(v)
+			}
+		}
+		rows = append(rows, tokens)
+		return nil
+	})
+	return rows, err
+}
+
+// DecodeTable reads the header and all remaining CSV records from the input
+// and returns the header fields and a map from header field names to values
+// for each record. It requires the input to contain a header, unless the
+// header was already processed with DecodeHeader.
+func (d *Decoder) DecodeTable() ([]string, []map[string]string, error) {
+	if !d.readHeader && len(d.headerKeys) == 0 {
+		return nil, nil, fmt.Errorf("csv: DecodeTable requires a header")
+	}
+	rows := make([]map[string]string, 0)
+	err := d.readRecords(func(line string) error {
+		tokens, err := d.split(line)
+		if err != nil {
+			return &DecodeError{d.lineNo, 0, "", err}
+		}
+		if len(tokens) != len(d.headerKeys) {
+			return &DecodeError{d.lineNo, 0, "number of fields does not match header", nil}
+		}
+		row := make(map[string]string, len(tokens))
+		m := reflect.ValueOf(row)
+		for i, fName := range d.headerKeys {
+			v := tokens[i]
+			if d.trim {
+				v = d.trimValue(v)
+			}
+			if d.nullVals[v] {
+				v = ""
+			}
+			key, err := d.mapKey(m, fName)
+			if err != nil {
+				return &DecodeError{d.lineNo, i + 1, fName, err}
+			}
+			m.SetMapIndex(key, reflect.ValueOf(v))
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return d.headerKeys, rows, err
+}
+
+// typeHeader prepares header fields from the type definition of typ.
+func (d *Decoder) typeHeader(typ reflect.Type) error {
+	tinfo, err := getTypeInfo(indirectType(typ), d.tag)
+	if err != nil {
+		return fmt.Errorf("csv: %v", err)
+	}
+	for _, finfo := range tinfo.orderedFields() {
+		if finfo.flags&(fAny|fLine|fRecord) != 0 {
+			continue
+		}
+		// leave skipped positions empty
+		for d.skipCols[len(d.headerKeys)] {
+			d.headerKeys = append(d.headerKeys, "")
+		}
+		d.headerKeys = append(d.headerKeys, d.fieldName(&finfo))
+	}
 	if len(d.headerKeys) == 0 {
+		return fmt.Errorf("csv: cannot derive header from type %s without mapped fields", indirectType(typ))
+	}
+	for i := range d.skipCols {
+		for len(d.headerKeys) <= i {
+			d.headerKeys = append(d.headerKeys, "")
+		}
+	}
+	return nil
+}
+
+// DecodeHeader reads CSV head fields from line and stores them as internal
+// Decoder state required to map CSV records later on.
+func (d *Decoder) DecodeHeader(line string) ([]string, error) {
+	if err := d.checkConfig(); err != nil {
+		return nil, err
+	}
+	keys, err := d.parseHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	d.headerKeys = keys
+	d.unknown = nil
+	d.repeated = nil
+	return d.headerKeys, nil
+}
+
+// parseHeader splits line into header fields and applies trimming and
+// renaming.
+func (d *Decoder) parseHeader(line string) ([]string, error) {
+	keys, err := d.split(line)
+	if err != nil {
+		return nil, &DecodeError{d.lineNo, 0, "", err}
+	}
+	if len(keys) == 0 {
 		return nil, fmt.Errorf("csv: empty header")
 	}
-	if d.trim {
-		for i, v := range d.headerKeys {
-			d.headerKeys[i] = strings.TrimSpace(v)
+	if n := len(keys); n > 1 && keys[n-1] == "" {
+		switch d.headComma {
+		case DropTrailingComma:
+			keys = keys[:n-1]
+			d.dropTrail = true
+		case RejectTrailingComma:
+			return nil, &DecodeError{d.lineNo, n, "", fmt.Errorf("empty header field after trailing separator")}
+		}
+	}
+	if d.trimHead {
+		for i, v := range keys {
+			keys[i] = d.trimValue(v)
+		}
+	}
+	for i, v := range keys {
+		if n, ok := d.rename[v]; ok {
+			keys[i] = n
+		}
+	}
+	return keys, nil
+}
+
+// isHeader returns true when line contains the current header fields.
+func (d *Decoder) isHeader(line string) bool {
+	keys, err := d.parseHeader(line)
+	return err == nil && equalFields(keys, d.headerKeys)
+}
+
+// equalFields returns true when a and b contain the same fields in the same
+// order.
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// split splits line into fields at the separator rune. Fields may be quoted
+// to contain separators, in which case surrounding quotes are removed and
+// escaped double quotes inside are replaced by a single quote. When trimming
+// is enabled, a quote preceeded by whitespace only is recognized as opening
+// quote as well. Quotes inside unquoted fields are an error unless LazyQuotes
+// is enabled.
+func (d *Decoder) split(line string) ([]string, error) {
+	if d.sepRe != nil {
+		return d.sepRe.Split(line, -1), nil
+	}
+	var (
+		fields = make([]string, 0, len(d.headerKeys))
+		field  strings.Builder
+		quoted bool // inside a quoted section
+		opened bool // field started with a quote
+		closed bool // quoted section of the field has ended
+	)
+	wrapper := rune(Wrapper[0])
+	for i, w := 0, 0; i < len(line); i += w {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		w = size
+		switch {
+		case d.escapes && r == '\\' && i+w < len(line) && (quoted || !closed || d.lazyQuotes):
+			// unescape backslash sequences
+			n, nsize := utf8.DecodeRuneInString(line[i+w:])
+			w += nsize
+			switch n {
+			case 't':
+				n = '\t'
+			case 'n':
+				n = '\n'
+			case 'r':
+				n = '\r'
+			}
+			field.WriteRune(n)
+		case quoted && r == wrapper:
+			// escaped quote or end of quoted section
+			if strings.HasPrefix(line[i+w:], Wrapper) {
+				field.WriteRune(wrapper)
+				w += len(Wrapper)
+			} else {
+				quoted, closed = false, true
+			}
+		case quoted:
+			field.WriteRune(r)
+		case r == d.sep:
+			fields = append(fields, field.String())
+			field.Reset()
+			opened, closed = false, false
+		case closed && !d.lazyQuotes && !(d.trim && d.trimValue(string(r)) == ""):
+			return nil, fmt.Errorf("extraneous data after closing quote")
+		case r == wrapper && !opened && (field.Len() == 0 || d.trim && d.trimValue(field.String()) == ""):
+			// opening quote, drop leading whitespace
+			field.Reset()
+			quoted, opened = true, true
+		case r == wrapper && !opened && !d.lazyQuotes:
+			return nil, fmt.Errorf("bare quote in non-quoted field")
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted field")
+	}
+	// drop the empty field following a trailing separator
+	if (d.trimTrail || d.dropTrail) && !opened && field.Len() == 0 && len(fields) > 0 {
+		return fields, nil
+	}
+	return append(fields, field.String()), nil
+}
+
+// DecodeRecord extracts CSV record fields from line and stores them into
+// Go value v.
+func (d *Decoder) DecodeRecord(v interface{}, line string) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return fmt.Errorf("csv: non-pointer passed to DecodeRecord")
+	}
+	return d.unmarshal(val, line)
+}
+
+// DecodeLine decodes a single CSV record from line into a new value of type T
+// using header to map fields. When header is empty, it is derived from the
+// type definition of T. Options in opts are applied to the transient Decoder
+// before decoding. DecodeLine is useful to parse individual records outside
+// of a stream.
+func DecodeLine[T any](header []string, line string, opts ...func(*Decoder)) (T, error) {
+	var v T
+	d := NewDecoder(strings.NewReader(""))
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.checkConfig(); err != nil {
+		return v, err
+	}
+	if len(header) == 0 {
+		if err := d.typeHeader(reflect.TypeOf(&v).Elem()); err != nil {
+			return v, err
+		}
+	} else {
+		d.headerKeys = make([]string, len(header))
+		for i, key := range header {
+			if d.trimHead {
+				key = d.trimValue(key)
+			}
+			if n, ok := d.rename[key]; ok {
+				key = n
+			}
+			d.headerKeys[i] = key
+		}
+	}
+	err := d.DecodeRecord(&v, line)
+	return v, err
+}
+
+func (d *Decoder) unmarshal(val reflect.Value, line string) error {
+	// split line into tokens
+	tokens, err := d.split(line)
+	if err != nil {
+		return &DecodeError{d.lineNo, 0, "", err}
+	}
+
+	// recover numbers with grouping separators that were split into fields
+	if d.mergeNums && len(tokens) > len(d.headerKeys) {
+		tokens = d.mergeNumeric(val.Type(), tokens)
+	}
+
+	if len(tokens) != len(d.headerKeys) {
+		return &DecodeError{d.lineNo, 0, "number of fields does not match header", nil}
+	}
+
+	if d.stats != nil {
+		d.collectStats(tokens)
+	}
+
+	if err := d.checkRequired(tokens); err != nil {
+		return err
+	}
+
+	// Load value from interface, but only if the result will be
+	// usefully addressable.
+	val = derefValue(val)
+
+	if val.CanInterface() && val.Type().Implements(unmarshalerType) {
+		// This is an unmarshaler with a non-pointer receiver,
+		// so it's likely to be incorrect, but we do what we're told.
+		return val.Interface().(Unmarshaler).UnmarshalCSV(d.headerKeys, tokens)
+	}
+
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(unmarshalerType) {
+			return pv.Interface().(Unmarshaler).UnmarshalCSV(d.headerKeys, tokens)
+		}
+	}
+
+	// find unmapped header fields once the target type is known
+	if d.unknown == nil && val.Kind() == reflect.Struct {
+		d.unknown = make([]string, 0)
+		d.repeated = make([]bool, len(d.headerKeys))
+		seen := make(map[string]bool)
+		for i, fName := range d.headerKeys {
+			if d.skipCols[i] {
+				continue
+			}
+			d.repeated[i] = seen[fName]
+			seen[fName] = true
+			if _, f := d.findStructField(val, fName, false); !f.IsValid() {
+				d.unknown = append(d.unknown, fName)
+			}
+		}
+	}
+
+	// store the record's line number and raw fields
+	if val.Kind() == reflect.Struct {
+		if err := d.setLineNo(val); err != nil {
+			return err
+		}
+		if err := d.setRecord(val, tokens); err != nil {
+			return err
+		}
+	}
+
+	// map struct fields; explicitly mapped fields take precedence over the
+	// `any` field, so repeated header fields are treated as unmapped
+	var filled map[int]bool
+	if d.omitEmbed {
+		filled = make(map[int]bool)
+	}
+	for i, fName := range d.headerKeys {
+		if d.skipCols[i] {
+			continue
+		}
+		if d.trim {
+			tokens[i] = d.trimValue(tokens[i])
+		}
+		if d.nullVals[tokens[i]] {
+			tokens[i] = ""
+		}
+
+		// handle maps
+		if val.Kind() == reflect.Map {
+			if val.IsNil() {
+				val.Set(reflect.MakeMap(val.Type()))
+			}
+			key, err := d.mapKey(val, fName)
+			if err != nil {
+				return &DecodeError{d.lineNo, i + 1, fName, err}
+			}
+			val.SetMapIndex(key, reflect.ValueOf(tokens[i]))
+			continue
+		}
+
+		repeated := i < len(d.repeated) && d.repeated[i]
+		finfo, f := d.findStructField(val, fName, repeated)
+		if !f.IsValid() && repeated {
+			// without an `any` field the last repeated value wins
+			finfo, f = d.findStructField(val, fName, false)
+		}
+		if !f.IsValid() {
+			if d.skipUnknown {
+				continue
+			} else {
+				return &DecodeError{d.lineNo, i + 1, fName, fmt.Errorf("field not found")}
+			}
+		}
+		if err := d.fieldSetter(finfo, f.Type(), fName)(f, tokens[i]); err != nil {
+			return &DecodeError{d.lineNo, i + 1, fName, err}
+		}
+		if filled != nil && tokens[i] != "" {
+			filled[finfo.idx[0]] = true
+		}
+	}
+
+	if d.omitEmbed {
+		clearEmbedded(val, filled)
+	}
+	return nil
+}
+
+// clearEmbedded resets embedded struct pointers in struct val to nil when
+// none of their fields were filled. filled contains the indices of top-level
+// struct fields which received a non-empty value.
+func clearEmbedded(val reflect.Value, filled map[int]bool) {
+	if val.Kind() != reflect.Struct {
+		return
+	}
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.Anonymous || f.Type.Kind() != reflect.Ptr || f.Type.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		if fv := val.Field(i); !filled[i] && fv.CanSet() {
+			fv.Set(reflect.Zero(f.Type))
+		}
+	}
+}
+
+// fieldSetter returns a function that decodes a CSV value into a struct field
+// of type typ described by finfo. name is the CSV header field name.
+func (d *Decoder) fieldSetter(finfo *fieldInfo, typ reflect.Type, name string) func(reflect.Value, string) error {
+	// use a custom field decoder when registered
+	if fn, ok := d.fieldDecs[name]; ok && finfo.flags&fAny == 0 {
+		return func(f reflect.Value, s string) error {
+			v, err := fn(s)
+			if err != nil {
+				return err
+			}
+			return assignValue(f, v)
+		}
+	}
+
+	// append unmapped values to `any` string slices in header order
+	if finfo.flags&fAny > 0 && typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.String {
+		return func(f reflect.Value, s string) error {
+			f.Set(reflect.Append(f, reflect.ValueOf(s).Convert(typ.Elem())))
+			return nil
+		}
+	}
+
+	// parse time values with a per-field or default layout, empty values
+	// result in a zero time
+	if indirectType(typ) == timeType {
+		layouts := d.timeFmts
+		if finfo.format != "" {
+			layouts = []string{finfo.format}
+		}
+		return func(f reflect.Value, s string) error {
+			return setTime(f, s, layouts)
+		}
+	}
+
+	// decode key-value pairs into structs when requested
+	if finfo.kv != "" && indirectType(typ).Kind() == reflect.Struct {
+		return func(f reflect.Value, s string) error {
+			return d.setKeyValues(f, s, finfo.kv)
+		}
+	}
+
+	// split values into slice elements when requested
+	if k := indirectType(typ).Kind(); finfo.split != "" && (k == reflect.Slice || k == reflect.Array) {
+		return func(f reflect.Value, s string) error {
+			return d.setSlice(f, s, finfo.split, name)
+		}
+	}
+
+	// try text unmarshalers first
+	if typ.Implements(textUnmarshalerType) {
+		return func(f reflect.Value, s string) error {
+			if f.Kind() == reflect.Ptr && f.IsNil() {
+				f.Set(reflect.New(typ.Elem()))
+			}
+			return f.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+	if reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return func(f reflect.Value, s string) error {
+			return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	// otherwise set simple value directly
+	return func(f reflect.Value, s string) error {
+		return d.setValue(f, s, name)
+	}
+}
+
+// fieldName returns the name used to match CSV header fields against the
+// struct field described by finfo.
+func (d *Decoder) fieldName(finfo *fieldInfo) string {
+	if d.goNames {
+		return finfo.goName
+	}
+	return finfo.name
+}
+
+// match returns true when CSV header field name header matches struct field
+// name tag.
+func (d *Decoder) match(header, tag string) bool {
+	if d.matcher != nil {
+		return d.matcher(header, tag)
+	}
+	return header == tag
+}
+
+// setLineNo stores the current line number in all fields tagged with `line`.
+func (d *Decoder) setLineNo(val reflect.Value) error {
+	tinfo, err := getTypeInfo(val.Type(), d.tag)
+	if err != nil {
+		return nil
+	}
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fLine == 0 {
+			continue
+		}
+		if err := d.setValue(finfo.value(val), strconv.Itoa(d.lineNo), finfo.name); err != nil {
+			return &DecodeError{d.lineNo, 0, finfo.name, err}
 		}
 	}
-	return d.headerKeys, nil
+	return nil
 }
 
-// DecodeRecord extracts CSV record fields from line and stores them into
-// Go value v.
-func (d *Decoder) DecodeRecord(v interface{}, line string) error {
-	val := reflect.ValueOf(v)
-	if val.Kind() != reflect.Ptr {
-		return fmt.Errorf("csv: non-pointer passed to DecodeRecord")
+// setRecord stores a copy of the record tokens in all fields tagged with
+// `record`.
+func (d *Decoder) setRecord(val reflect.Value, tokens []string) error {
+	tinfo, err := getTypeInfo(val.Type(), d.tag)
+	if err != nil {
+		return nil
 	}
-	return d.unmarshal(val, line)
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fRecord == 0 {
+			continue
+		}
+		f := finfo.value(val)
+		if f.Kind() != reflect.Slice || f.Type().Elem().Kind() != reflect.String {
+			return &DecodeError{d.lineNo, 0, finfo.name, fmt.Errorf("record field must be a string slice")}
+		}
+		rec := reflect.MakeSlice(f.Type(), len(tokens), len(tokens))
+		for i, v := range tokens {
+			rec.Index(i).SetString(v)
+		}
+		f.Set(rec)
+	}
+	return nil
 }
 
-func (d *Decoder) unmarshal(val reflect.Value, line string) error {
-	// split line into tokens
-	tokens := strings.Split(line, string(d.sep))
-
-	// combine tokens between ""
-	combined := make([]string, 0, len(tokens))
-	var merged string
-	for _, v := range tokens {
-		// unquote and merge multiple tokens, when separated
-		switch true {
-		case len(v) == 1 && strings.HasPrefix(v, Wrapper):
-			// (1) .. ,",", .. (2) .. ," text,", ..
-			if merged == "" {
-				merged += string(d.sep)
-			} else {
-				merged += string(d.sep)
-				combined = append(combined, merged)
-				merged = ""
-			}
-		case len(v) >= 2 && strings.HasPrefix(v, Wrapper) && strings.HasSuffix(v, Wrapper):
-			// (1) .. ,"", .. (2) ..," text text ", ..
-			combined = append(combined, v[1:len(v)])
-			merged = ""
-		case strings.HasPrefix(v, Wrapper):
-			// .. ," text, more text", .. (1st part)
-			merged = v[1:]
-		case strings.HasSuffix(v, Wrapper):
-			// .. ," text, more text", .. (2nd part)
-			merged = strings.Join([]string{merged, v[:len(v)-1]}, string(d.sep))
-			combined = append(combined, merged)
-			merged = ""
-		default:
-			// .. ," text, more, text", .. (middle part)
-			if merged != "" {
-				merged = strings.Join([]string{merged, v}, string(d.sep))
-			} else {
-				combined = append(combined, v)
+// mergeNumeric merges tokens of numeric fields in struct type typ that were
+// split at an unquoted grouping separator, such as 1,234 with comma as field
+// separator. Merging stops when the number of tokens matches the header. The
+// original tokens are returned when typ is not a struct.
+func (d *Decoder) mergeNumeric(typ reflect.Type, tokens []string) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return tokens
+	}
+	tinfo, err := getTypeInfo(typ, d.tag)
+	if err != nil {
+		return tokens
+	}
+	excess := len(tokens) - len(d.headerKeys)
+	merged := make([]string, 0, len(d.headerKeys))
+	for i := 0; i < len(tokens); i++ {
+		v := tokens[i]
+		if h := len(merged); excess > 0 && h < len(d.headerKeys) && isLeadingGroup(v) {
+			if finfo := d.lookupField(tinfo, d.headerKeys[h], false); finfo != nil && isNumeric(typ.FieldByIndex(finfo.idx).Type) {
+				for excess > 0 && i+1 < len(tokens) && isDigitGroup(tokens[i+1]) {
+					i++
+					v += tokens[i]
+					excess--
+				}
 			}
 		}
+		merged = append(merged, v)
 	}
-	tokens = combined
+	return merged
+}
 
-	if len(tokens) != len(d.headerKeys) {
-		return &DecodeError{d.lineNo, 0, "number of fields does not match header", nil}
+// isLeadingGroup returns true when s is an optionally signed number of up to
+// three digits that may start a number with grouping separators.
+func isLeadingGroup(s string) bool {
+	s = strings.TrimLeft(strings.TrimSpace(s), "+-")
+	if len(s) == 0 || len(s) > 3 {
+		return false
 	}
+	return strings.Trim(s, "0123456789") == ""
+}
 
-	// Load value from interface, but only if the result will be
-	// usefully addressable.
-	val = derefValue(val)
-
-	if val.CanInterface() && val.Type().Implements(unmarshalerType) {
-		// This is an unmarshaler with a non-pointer receiver,
-		// so it's likely to be incorrect, but we do what we're told.
-		return val.Interface().(Unmarshaler).UnmarshalCSV(d.headerKeys, tokens)
+// isDigitGroup returns true when s is a group of three digits, optionally
+// followed by a decimal fraction.
+func isDigitGroup(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) < 3 || strings.Trim(s[:3], "0123456789") != "" {
+		return false
+	}
+	if frac := s[3:]; frac != "" {
+		return frac[0] == '.' && strings.Trim(frac[1:], "0123456789") == ""
 	}
+	return true
+}
 
-	if val.CanAddr() {
-		pv := val.Addr()
-		if pv.CanInterface() && pv.Type().Implements(unmarshalerType) {
-			return pv.Interface().(Unmarshaler).UnmarshalCSV(d.headerKeys, tokens)
-		}
+// isNumeric returns true when typ or the type it points to is an integer or
+// floating point type.
+func isNumeric(typ reflect.Type) bool {
+	switch indirectType(typ).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
 	}
+	return false
+}
 
-	// map struct fields
+// checkRequired evaluates conditional requirements against the record tokens.
+func (d *Decoder) checkRequired(tokens []string) error {
+	if len(d.requireIf) == 0 {
+		return nil
+	}
+	record := make(map[string]string, len(tokens))
 	for i, fName := range d.headerKeys {
+		v := tokens[i]
 		if d.trim {
-			tokens[i] = strings.TrimSpace(tokens[i])
+			v = d.trimValue(v)
 		}
-
-		// remove double quotes
-		tokens[i] = strings.Replace(tokens[i], "\"\"", "\"", -1)
-
-		// handle maps
-		if val.Kind() == reflect.Map {
-			val.SetMapIndex(reflect.ValueOf(fName), reflect.ValueOf(tokens[i]))
+		record[fName] = v
+	}
+	for _, req := range d.requireIf {
+		if !req.cond(record) || record[req.field] != "" {
 			continue
 		}
-
-		_, f := d.findStructField(val, fName)
-		if !f.IsValid() {
-			if d.skipUnknown {
-				continue
-			} else {
-				return &DecodeError{d.lineNo, i + 1, fName, fmt.Errorf("field not found")}
+		for i, fName := range d.headerKeys {
+			if fName == req.field {
+				return &DecodeError{d.lineNo, i + 1, fName, fmt.Errorf("required field is empty")}
 			}
 		}
+		return &DecodeError{d.lineNo, 0, fmt.Sprintf("required field %s is missing", req.field), nil}
+	}
+	return nil
+}
 
-		// try text unmarshalers first
-		if f.CanInterface() && f.Type().Implements(textUnmarshalerType) {
-			if err := f.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(tokens[i])); err != nil {
-				return &DecodeError{d.lineNo, i + 1, fName, err}
-			}
-			continue
+func (d *Decoder) collectStats(tokens []string) {
+	for i, fName := range d.headerKeys {
+		v := tokens[i]
+		if d.trim {
+			v = d.trimValue(v)
 		}
-
-		if f.CanAddr() {
-			pv := f.Addr()
-			if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) {
-				if err := pv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(tokens[i])); err != nil {
-					return &DecodeError{d.lineNo, i + 1, fName, err}
-				}
-				continue
-			}
+		l := utf8.RuneCountInString(v)
+		st := d.stats[fName]
+		if st.Count == 0 || l < st.MinLen {
+			st.MinLen = l
 		}
-
-		// otherwise set simple value directly
-		if err := setValue(f, tokens[i], fName); err != nil {
-			return &DecodeError{d.lineNo, i + 1, fName, err}
+		if l > st.MaxLen {
+			st.MaxLen = l
 		}
+		if l == 0 {
+			st.Empty++
+		}
+		st.Count++
+		d.stats[fName] = st
 	}
-
-	return nil
 }
 
-func (d *Decoder) findStructField(val reflect.Value, name string) (*fieldInfo, reflect.Value) {
+// findStructField returns the field matching name or the `any` field when no
+// such field exists. When unmapped is true, only the `any` field is returned.
+func (d *Decoder) findStructField(val reflect.Value, name string, unmapped bool) (*fieldInfo, reflect.Value) {
 	typ := val.Type()
-	tinfo, err := getTypeInfo(typ)
+	tinfo, err := getTypeInfo(typ, d.tag)
 	if err != nil {
 		return nil, reflect.Value{}
 	}
 
-	var finfo *fieldInfo
+	// nothing found
+	finfo := d.lookupField(tinfo, name, unmapped)
+	if finfo == nil {
+		return nil, reflect.Value{}
+	}
+
+	// allocate memory for pointer values in structs
+	v := finfo.value(val)
+	if v.Type().Kind() == reflect.Ptr && v.IsNil() && v.CanSet() {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+
+	return finfo, v
+}
+
+// lookupField returns the field info matching name or the `any` field when no
+// such field exists. When unmapped is true, only the `any` field is returned.
+func (d *Decoder) lookupField(tinfo *typeInfo, name string, unmapped bool) *fieldInfo {
 	any := -1
 	// pick the correct field based on name and flags
-	for i, v := range tinfo.fields {
+	for i := range tinfo.fields {
+		v := &tinfo.fields[i]
+
 		// save `any` field in case
 		if v.flags&fAny > 0 {
 			any = i
 		}
 
 		// field name must match
-		if v.name != name {
+		if unmapped || v.flags&(fLine|fRecord) > 0 || !d.match(name, d.fieldName(v)) {
 			continue
 		}
+		return v
+	}
+	if any >= 0 {
+		return &tinfo.fields[any]
+	}
+	return nil
+}
 
-		finfo = &v
-		break
+// assignValue assigns v to dst, converting v to the type of dst if necessary.
+// Pointer fields are allocated as needed. A nil v leaves dst unchanged.
+func assignValue(dst reflect.Value, v interface{}) error {
+	src := reflect.ValueOf(v)
+	if !src.IsValid() {
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr && !src.Type().AssignableTo(dst.Type()) {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
 	}
+	switch {
+	case src.Type().AssignableTo(dst.Type()):
+		dst.Set(src)
+	case src.Type().ConvertibleTo(dst.Type()):
+		dst.Set(src.Convert(dst.Type()))
+	default:
+		return fmt.Errorf("cannot assign %s to %s", src.Type(), dst.Type())
+	}
+	return nil
+}
 
-	if finfo == nil && any >= 0 {
-		finfo = &tinfo.fields[any]
+// boolValues contains common boolean representations that are matched
+// case-insensitive before falling back to strconv.ParseBool.
+var boolValues = map[string]bool{
+	"true":  true,
+	"false": false,
+	"yes":   true,
+	"no":    false,
+	"on":    true,
+	"off":   false,
+}
+
+func parseBool(s string) (bool, error) {
+	s = strings.TrimSpace(s)
+	if b, ok := boolValues[strings.ToLower(s)]; ok {
+		return b, nil
 	}
+	return strconv.ParseBool(s)
+}
 
-	// nothing found
-	if finfo == nil {
-		return nil, reflect.Value{}
+func setTime(dst reflect.Value, src string, layouts []string) error {
+	if src == "" {
+		return nil
 	}
+	var (
+		t    time.Time
+		err  error
+		ferr error
+	)
+	for _, layout := range layouts {
+		if t, err = time.Parse(layout, src); err == nil {
+			break
+		}
+		if ferr == nil {
+			ferr = err
+		}
+	}
+	if err != nil {
+		return ferr
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	dst.Set(reflect.ValueOf(t))
+	return nil
+}
 
-	// allocate memory for pointer values in structs
-	v := finfo.value(val)
-	if v.Type().Kind() == reflect.Ptr && v.IsNil() && v.CanSet() {
-		v.Set(reflect.New(v.Type().Elem()))
+// setKeyValues decodes key-value pairs in src into the fields of struct or
+// struct pointer dst. The first rune of seps separates pairs and the optional
+// second rune separates keys from values, which defaults to a colon. Keys are
+// matched against struct field names like header fields.
+func (d *Decoder) setKeyValues(dst reflect.Value, src, seps string) error {
+	if src == "" {
+		return nil
+	}
+	sep, kvSep := seps, ":"
+	if _, n := utf8.DecodeRuneInString(seps); n < len(seps) {
+		sep, kvSep = seps[:n], seps[n:]
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	tinfo, err := getTypeInfo(dst.Type(), d.tag)
+	if err != nil {
+		return err
+	}
+	for _, pair := range strings.Split(src, sep) {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, kvSep)
+		if !ok {
+			return fmt.Errorf("invalid key-value pair %q", pair)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		finfo := d.lookupField(tinfo, key, false)
+		if finfo == nil {
+			if d.skipUnknown {
+				continue
+			}
+			return fmt.Errorf("key %s not found", key)
+		}
+		f := finfo.value(dst)
+		if f.Kind() == reflect.Ptr && f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		if err := d.fieldSetter(finfo, f.Type(), key)(f, value); err != nil {
+			return fmt.Errorf("key %s: %v", key, err)
+		}
 	}
+	return nil
+}
 
-	return finfo, v
+// setSlice splits src at sep and stores the decoded elements into the slice,
+// array or pointer to such types dst. The number of elements must match the
+// length of arrays.
+func (d *Decoder) setSlice(dst reflect.Value, src, sep, fName string) error {
+	if src == "" {
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+	parts := strings.Split(src, sep)
+	var slice reflect.Value
+	if dst.Kind() == reflect.Array {
+		if len(parts) != dst.Len() {
+			return fmt.Errorf("expected %d elements, got %d", dst.Len(), len(parts))
+		}
+		slice = reflect.New(dst.Type()).Elem()
+	} else {
+		slice = reflect.MakeSlice(dst.Type(), len(parts), len(parts))
+	}
+	for i, v := range parts {
+		if d.trim {
+			v = d.trimValue(v)
+		}
+		elem := slice.Index(i)
+		if elem.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if elem.Type().Implements(textUnmarshalerType) {
+			if err := elem.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(v)); err != nil {
+				return err
+			}
+			continue
+		}
+		if elem.Addr().Type().Implements(textUnmarshalerType) {
+			if err := elem.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(v)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.setValue(elem, v, fName); err != nil {
+			return err
+		}
+	}
+	dst.Set(slice)
+	return nil
+}
+
+var enumMap = make(map[reflect.Type]map[string]reflect.Value)
+var enumLock sync.RWMutex
+
+// RegisterStringerEnum registers the names of enum values for decoding. The
+// type of zeroValue must implement fmt.Stringer and values are all valid values
+// of this type. When decoding into a field of this type, the CSV value is
+// matched against the String representation of each value. Since encoding
+// already uses String, this makes enums round-trip through CSV.
+//
+// RegisterStringerEnum panics when zeroValue does not implement fmt.Stringer
+// or when values are of a different type.
+func RegisterStringerEnum(zeroValue interface{}, values ...interface{}) {
+	typ := reflect.TypeOf(zeroValue)
+	if typ == nil || !typ.Implements(stringerType) {
+		panic(fmt.Sprintf("csv: enum type %v does not implement fmt.Stringer", typ))
+	}
+	names := make(map[string]reflect.Value, len(values)+1)
+	for _, v := range append([]interface{}{zeroValue}, values...) {
+		val := reflect.ValueOf(v)
+		if val.Type() != typ {
+			panic(fmt.Sprintf("csv: enum value %v is not of type %v", v, typ))
+		}
+		names[v.(fmt.Stringer).String()] = val
+	}
+	enumLock.Lock()
+	enumMap[typ] = names
+	enumLock.Unlock()
+}
+
+// enumValue returns the registered enum value of type typ with name src.
+func enumValue(typ reflect.Type, src string) (reflect.Value, bool, error) {
+	enumLock.RLock()
+	names, ok := enumMap[typ]
+	enumLock.RUnlock()
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+	if v, ok := names[src]; ok {
+		return v, true, nil
+	}
+	return reflect.Value{}, true, fmt.Errorf("invalid value %s for %s", src, typ)
+}
+
+// trimValue removes the trim cutset or whitespace from both ends of s.
+func (d *Decoder) trimValue(s string) string {
+	if d.cutset == "" {
+		return strings.TrimSpace(s)
+	}
+	return strings.Trim(s, d.cutset)
+}
+
+// numeric prepares numeric value src for parsing by removing digit separators
+// when allowed.
+func (d *Decoder) numeric(src string) string {
+	if d.underscore {
+		return strings.ReplaceAll(src, "_", "")
+	}
+	return src
+}
+
+// mapKey returns the key for storing field name into map m according to the
+// Decoder's duplicate key policy.
+func (d *Decoder) mapKey(m reflect.Value, name string) (reflect.Value, error) {
+	kt := m.Type().Key()
+	key := reflect.ValueOf(name).Convert(kt)
+	if d.dupKeys == OverwriteDuplicates || !m.MapIndex(key).IsValid() {
+		return key, nil
+	}
+	if d.dupKeys == RejectDuplicates {
+		return key, fmt.Errorf("duplicate field %s", name)
+	}
+	for n := 2; ; n++ {
+		key = reflect.ValueOf(name + "_" + strconv.Itoa(n)).Convert(kt)
+		if !m.MapIndex(key).IsValid() {
+			return key, nil
+		}
+	}
+}
+
+// convError handles a failed conversion of src into the numeric, boolean or
+// enum field dst. In lenient mode, dst is reset to its zero value and err is passed
+// to the type error callback instead of being returned.
+func (d *Decoder) convError(dst reflect.Value, src, fName string, err error) error {
+	if !d.lenient {
+		return err
+	}
+	dst.Set(reflect.Zero(dst.Type()))
+	if d.typeErrFn != nil {
+		d.typeErrFn(d.lineNo, fName, src, err)
+	}
+	return nil
 }
 
-func setValue(dst reflect.Value, src, fName string) error {
+func (d *Decoder) setValue(dst reflect.Value, src, fName string) error {
 	if src == "" {
 		return nil
 	}
@@ -479,6 +2294,25 @@ func setValue(dst reflect.Value, src, fName string) error {
 		dst = dst.Elem()
 	}
 
+	// parse URLs which don't implement encoding.TextUnmarshaler
+	if dst.Type() == urlType {
+		u, err := url.Parse(strings.TrimSpace(src))
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	// decode registered enums by name
+	if v, ok, err := enumValue(dst.Type(), strings.TrimSpace(src)); ok {
+		if err != nil {
+			return d.convError(dst0, src, fName, err)
+		}
+		dst.Set(v)
+		return nil
+	}
+
 	switch dst.Kind() {
 	case reflect.Map:
 		// map must have map[string]string signature or map value
@@ -492,9 +2326,13 @@ func setValue(dst reflect.Value, src, fName string) error {
 		default:
 			return fmt.Errorf("map key type must be string")
 		}
+		key, err := d.mapKey(dst, fName)
+		if err != nil {
+			return err
+		}
 		switch t.Elem().Kind() {
 		case reflect.String:
-			dst.SetMapIndex(reflect.ValueOf(fName), reflect.ValueOf(src).Convert(t.Elem()))
+			dst.SetMapIndex(key, reflect.ValueOf(src).Convert(t.Elem()))
 		default:
 			// create new map entry and contents if it's pointer type
 			val := reflect.New(t.Elem()).Elem()
@@ -513,39 +2351,52 @@ func setValue(dst reflect.Value, src, fName string) error {
 					}
 				}
 			} else {
-				if err := setValue(val, src, fName); err != nil {
+				if err := d.setValue(val, src, fName); err != nil {
 					return err
 				}
 			}
-			dst.SetMapIndex(reflect.ValueOf(fName), val)
+			dst.SetMapIndex(key, val)
 		}
 
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		src = d.numeric(src)
 		i, err := strconv.ParseInt(src, 10, dst.Type().Bits())
 		if err != nil {
-			return err
+			return d.convError(dst0, src, fName, err)
 		}
 		dst.SetInt(i)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		i, err := strconv.ParseUint(src, 10, dst.Type().Bits())
+		src = d.numeric(src)
+		if strings.HasPrefix(src, "-") {
+			return d.convError(dst0, src, fName, fmt.Errorf("negative value %s for unsigned field", src))
+		}
+		// ParseUint rejects an explicit plus sign unlike ParseInt
+		i, err := strconv.ParseUint(strings.TrimPrefix(src, "+"), 10, dst.Type().Bits())
 		if err != nil {
-			return err
+			return d.convError(dst0, src, fName, err)
 		}
 		dst.SetUint(i)
 	case reflect.Float32, reflect.Float64:
+		src = d.numeric(src)
 		i, err := strconv.ParseFloat(src, dst.Type().Bits())
 		if err != nil {
-			return err
+			return d.convError(dst0, src, fName, err)
 		}
 		dst.SetFloat(i)
 	case reflect.Bool:
-		i, err := strconv.ParseBool(strings.TrimSpace(src))
+		i, err := parseBool(src)
+		if err != nil && d.numBools {
+			if n, nerr := strconv.ParseInt(strings.TrimSpace(src), 10, 64); nerr == nil {
+				i, err = n != 0, nil
+			}
+		}
 		if err != nil {
-			return err
+			return d.convError(dst0, src, fName, err)
 		}
 		dst.SetBool(i)
 	case reflect.String:
-		dst.SetString(strings.TrimSpace(src))
+		// values are already trimmed when requested
+		dst.SetString(src)
 	case reflect.Slice:
 		// make sure it's a byte slice
 		if dst.Type().Elem().Kind() == reflect.Uint8 {
@@ -560,3 +2411,47 @@ func setValue(dst reflect.Value, src, fName string) error {
 	}
 	return nil
 }
+
+// byte order marks
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF32LE = []byte{0xFF, 0xFE, 0x00, 0x00}
+	bomUTF32BE = []byte{0x00, 0x00, 0xFE, 0xFF}
+)
+
+// encodingReader detects the input encoding from a byte order mark on first
+// read and converts input to UTF-8.
+type encodingReader struct {
+	r   *bufio.Reader
+	dec io.Reader
+}
+
+func newEncodingReader(r io.Reader) *encodingReader {
+	return &encodingReader{r: bufio.NewReader(r)}
+}
+
+func (r *encodingReader) Read(p []byte) (int, error) {
+	if r.dec == nil {
+		r.dec = r.detect()
+	}
+	return r.dec.Read(p)
+}
+
+func (r *encodingReader) detect() io.Reader {
+	b, _ := r.r.Peek(4)
+	switch {
+	case bytes.HasPrefix(b, bomUTF32LE):
+		return transform.NewReader(r.r, utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(b, bomUTF32BE):
+		return transform.NewReader(r.r, utf32.UTF32(utf32.BigEndian, utf32.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(b, bomUTF16LE):
+		return transform.NewReader(r.r, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(b, bomUTF16BE):
+		return transform.NewReader(r.r, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder())
+	case bytes.HasPrefix(b, bomUTF8):
+		r.r.Discard(len(bomUTF8))
+	}
+	return r.r
+}
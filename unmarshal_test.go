@@ -16,9 +16,21 @@ package csv
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode"
+
+	encunicode "golang.org/x/text/encoding/unicode"
 )
 
 type A struct {
@@ -541,3 +553,1788 @@ func TestLastRecordWithoutLF(t *testing.T) {
 	CheckA(t, a[0], A1)
 	CheckA(t, a[1], A2)
 }
+
+const CsvLenientBool = `s,b,i,f
+Hello,Yes,42,23.45
+Hello,OFF,42,23.45
+Hello,true,42,23.45`
+
+func TestUnmarshalLenientBool(t *testing.T) {
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvLenientBool), &a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 3 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 3)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], E2)
+	CheckA(t, a[2], A1)
+}
+
+const CsvBadInt = `s,b,i,f
+Hello,true,42,23.45
+Hello,true,43,23.45
+Hello,true,4x,23.45
+Hello,true,45,23.45`
+
+func TestCheck(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvWithHeader))
+	if err := NewDecoder(r).Check(A{}); err != nil {
+		t.Error(err)
+	}
+	r = bytes.NewReader([]byte(CsvBadInt))
+	err := NewDecoder(r).Check([]*A{})
+	if err == nil {
+		t.Errorf("expected error for invalid int")
+		return
+	}
+	derr, ok := err.(*DecodeError)
+	if !ok {
+		t.Errorf("invalid error type %T", err)
+		return
+	}
+	if derr.lineNo != 4 {
+		t.Errorf("invalid error line got=%d expected=%d", derr.lineNo, 4)
+	}
+}
+
+const CsvDuplicateField = `s,i,f,b,s
+Hello,42,23.45,true,Extra`
+
+func TestUnmarshalAnyDuplicate(t *testing.T) {
+	b := make([]*B, 0)
+	if err := Unmarshal([]byte(CsvDuplicateField), &b); err != nil {
+		t.Error(err)
+	}
+	if len(b) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(b), 1)
+		return
+	}
+	CheckB(t, b[0], B{"Hello", true, 42, 23.45, map[string]string{"s": "Extra"}})
+}
+
+func TestUnmarshalDuplicateLastWins(t *testing.T) {
+	a := make([]*A, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(CsvDuplicateField))).SkipUnknown(false)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	if a[0].String != "Extra" {
+		t.Errorf("invalid value got=%q expected=%q", a[0].String, "Extra")
+	}
+}
+
+type U struct {
+	Uint uint32 `csv:"u"`
+}
+
+func TestUnmarshalNegativeUint(t *testing.T) {
+	u := make([]*U, 0)
+	err := Unmarshal([]byte("u\n-1"), &u)
+	if err == nil {
+		t.Errorf("expected error for negative unsigned value")
+		return
+	}
+	if _, ok := err.(*DecodeError); !ok {
+		t.Errorf("invalid error type %T", err)
+	}
+	if exp := "negative value -1 for unsigned field"; !strings.Contains(err.Error(), exp) {
+		t.Errorf("invalid error got=%q expected=%q", err.Error(), exp)
+	}
+}
+
+const CsvFooter = `s,i,f,b
+Hello,42,23.45,true
+Hello World,43,24.56,false
+TOTAL,2 records`
+
+func TestUnmarshalSkipFooter(t *testing.T) {
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvFooter), &a); err == nil {
+		t.Errorf("expected error when not skipping footer")
+	}
+	r := bytes.NewReader([]byte(CsvFooter))
+	dec := NewDecoder(r).SkipFooter(1)
+	a = make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+}
+
+const CsvQuotedWhitespace = `s,i,f,b
+ "a,b" ,42,23.45,true
+"say ""hi""",42,23.45,true`
+
+func TestUnmarshalQuotedWhitespace(t *testing.T) {
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvQuotedWhitespace), &a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"a,b", true, 42, 23.45})
+	CheckA(t, a[1], A{`say "hi"`, true, 42, 23.45})
+}
+
+func TestUnmarshalStats(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvEmptyField))
+	dec := NewDecoder(r).Header(false).CollectStats(true)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	stats := dec.Stats()
+	for n, v := range map[string]ColumnStats{
+		"s": {5, 2, 0, 5},
+		"b": {5, 2, 0, 4},
+		"i": {5, 2, 0, 2},
+		"f": {5, 2, 0, 5},
+	} {
+		st, ok := stats[n]
+		if !ok {
+			t.Errorf("missing stats for column %s", n)
+			continue
+		}
+		if st != v {
+			t.Errorf("invalid stats for column %s got=%+v expected=%+v", n, st, v)
+		}
+	}
+}
+
+type AnySlice struct {
+	String string   `csv:"s"`
+	Bool   bool     `csv:"b"`
+	Int    int64    `csv:"i"`
+	Float  float64  `csv:"f"`
+	Any    []string `csv:",any"`
+}
+
+func TestUnmarshalAnySlice(t *testing.T) {
+	a := make([]*AnySlice, 0)
+	if err := Unmarshal([]byte(CsvAnyFields), &a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, &A{a[0].String, a[0].Bool, a[0].Int, a[0].Float}, A1)
+	if len(a[0].Any) != 2 || a[0].Any[0] != "X" || a[0].Any[1] != "Y" {
+		t.Errorf("invalid any slice got=%v expected=%v", a[0].Any, []string{"X", "Y"})
+	}
+}
+
+const CsvRename = `colS,colI,colF,b
+Hello,42,23.45,true`
+
+func TestUnmarshalRename(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvRename))
+	dec := NewDecoder(r).SkipUnknown(false).Rename(map[string]string{
+		"colS": "s",
+		"colI": "i",
+		"colF": "f",
+	})
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A1)
+}
+
+func TestUnmarshalUTF16(t *testing.T) {
+	enc := encunicode.UTF16(encunicode.LittleEndian, encunicode.UseBOM).NewEncoder()
+	in, err := enc.String("s,i,f,b\nHellö Wörld,43,24.56,false")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := bytes.NewReader([]byte(in))
+	dec := NewDecoder(r).AutoDetectEncoding(true)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A{"Hellö Wörld", false, 43, 24.56})
+}
+
+func TestUnmarshalUTF8BOM(t *testing.T) {
+	r := bytes.NewReader(append([]byte{0xEF, 0xBB, 0xBF}, CsvWithHeader...))
+	dec := NewDecoder(r).AutoDetectEncoding(true)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A1)
+}
+
+const CsvQuotedComment = `s,i,f,b
+# comment
+"#tag",42,23.45,true`
+
+func TestUnmarshalQuotedComment(t *testing.T) {
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvQuotedComment), &a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A{"#tag", true, 42, 23.45})
+}
+
+type ALine struct {
+	Line   int     `csv:",line"`
+	String string  `csv:"s"`
+	Bool   bool    `csv:"b"`
+	Int    int64   `csv:"i"`
+	Float  float64 `csv:"f"`
+}
+
+func TestUnmarshalLineNumber(t *testing.T) {
+	for _, v := range []struct {
+		Header bool
+		Data   string
+	}{
+		{true, CsvWithHeader},
+		{false, CsvComment},
+	} {
+		r := bytes.NewReader([]byte(v.Data))
+		dec := NewDecoder(r).Header(v.Header)
+		a := make([]*ALine, 0)
+		if err := dec.Decode(&a); err != nil {
+			t.Error(err)
+			continue
+		}
+		exp := []int{2}
+		if !v.Header {
+			exp = []int{2, 5}
+		}
+		if len(a) != len(exp) {
+			t.Errorf("invalid record count, got=%d expected=%d", len(a), len(exp))
+			continue
+		}
+		for i, l := range exp {
+			if a[i].Line != l {
+				t.Errorf("invalid line number got=%d expected=%d", a[i].Line, l)
+			}
+		}
+	}
+}
+
+func TestMarshalLineNumber(t *testing.T) {
+	b, err := Marshal([]ALine{{7, "Hello", true, 42, 23.45}})
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, b, CsvWithHeaderOut)
+}
+
+type Order struct {
+	Number int    `csv:"ordernumber"`
+	Name   string `csv:"customer_name"`
+}
+
+func TestUnmarshalHeaderMatcher(t *testing.T) {
+	normalize := func(s string) string {
+		s = strings.Replace(s, "#", "number", -1)
+		return strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, s)
+	}
+	r := bytes.NewReader([]byte("Order #,Customer Name\n42,John"))
+	dec := NewDecoder(r).SkipUnknown(false).HeaderMatcher(func(header, tag string) bool {
+		return normalize(header) == normalize(tag)
+	})
+	o := make([]Order, 0)
+	if err := dec.Decode(&o); err != nil {
+		t.Error(err)
+	}
+	if len(o) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(o), 1)
+		return
+	}
+	if o[0].Number != 42 || o[0].Name != "John" {
+		t.Errorf("invalid record got=%+v", o[0])
+	}
+}
+
+const CsvSixRecords = `s,i,f,b
+r1,1,1.0,true
+r2,2,2.0,true
+r3,3,3.0,true
+r4,4,4.0,true
+r5,5,5.0,true
+r6,x,6.0,true`
+
+func TestUnmarshalSkipLimit(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvSixRecords))
+	dec := NewDecoder(r).Skip(2).Limit(2)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"r3", true, 3, 3.0})
+	CheckA(t, a[1], A{"r4", true, 4, 4.0})
+}
+
+const CsvKeepEmptyLines = `
+s,i,f,b
+Hello,42,23.45,true
+
+Hello World,43,24.56,false`
+
+func TestUnmarshalKeepEmptyLines(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvKeepEmptyLines))
+	dec := NewDecoder(r).KeepEmptyLines(true)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 3 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 3)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], E5)
+	CheckA(t, a[2], A2)
+}
+
+const CsvRegexpSeparator = "s   i\tf b\nHello   42\t23.45 true"
+
+func TestUnmarshalSeparatorRegexp(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvRegexpSeparator))
+	dec := NewDecoder(r).SeparatorRegexp(regexp.MustCompile(`\s+`))
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A1)
+}
+
+type Names struct {
+	First string `csv:"first"`
+	Last  string `csv:"last"`
+}
+
+func TestUnmarshalFieldDecoder(t *testing.T) {
+	r := bytes.NewReader([]byte("first,last\njohn,DOE"))
+	dec := NewDecoder(r).
+		FieldDecoder("first", func(s string) (interface{}, error) {
+			return strings.ToUpper(s), nil
+		}).
+		FieldDecoder("last", func(s string) (interface{}, error) {
+			return strings.ToLower(s), nil
+		})
+	n := make([]Names, 0)
+	if err := dec.Decode(&n); err != nil {
+		t.Error(err)
+	}
+	if len(n) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(n), 1)
+		return
+	}
+	if n[0].First != "JOHN" || n[0].Last != "doe" {
+		t.Errorf("invalid record got=%+v", n[0])
+	}
+
+	r = bytes.NewReader([]byte("first,last\njohn,doe"))
+	dec = NewDecoder(r).FieldDecoder("first", func(s string) (interface{}, error) {
+		return nil, fmt.Errorf("failed")
+	})
+	if err := dec.Decode(&n); err == nil {
+		t.Errorf("expected error from field decoder")
+	}
+}
+
+const CsvNumericBools = `s,i,f,b
+Hello,42,23.45,1
+Hello,42,23.45,0
+Hello,42,23.45,2
+Hello,42,23.45,-1`
+
+func TestUnmarshalNumericBools(t *testing.T) {
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvNumericBools), &a); err == nil {
+		t.Errorf("expected error for numeric bool without option")
+	}
+	r := bytes.NewReader([]byte(CsvNumericBools))
+	dec := NewDecoder(r).NumericBools(true)
+	a = make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if len(a) != 4 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 4)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], E2)
+	CheckA(t, a[2], A1)
+	CheckA(t, a[3], A1)
+}
+
+func TestUnmarshalUnknownColumns(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvUnknownField))
+	dec := NewDecoder(r)
+	if dec.UnknownColumns() != nil {
+		t.Errorf("expected nil unknown columns before decoding")
+	}
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if u := dec.UnknownColumns(); len(u) != 1 || u[0] != "x" {
+		t.Errorf("invalid unknown columns got=%v expected=%v", u, []string{"x"})
+	}
+
+	r = bytes.NewReader([]byte(CsvAnyFields))
+	dec = NewDecoder(r)
+	b := make([]*B, 0)
+	if err := dec.Decode(&b); err != nil {
+		t.Error(err)
+	}
+	if u := dec.UnknownColumns(); len(u) != 0 {
+		t.Errorf("invalid unknown columns got=%v expected none", u)
+	}
+}
+
+const (
+	CsvMultiLine    = "s,i,f,b\n\"Hello\n# World\n\",42,23.45,true\nHello World,43,24.56,false"
+	CsvUnterminated = "s,i,f,b\n\"Hello,42,23.45,true\nHello World,43,24.56,false\nHello World,43,24.56,false\nHello World,43,24.56,false"
+)
+
+func TestUnmarshalMultiLine(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvMultiLine))
+	dec := NewDecoder(r)
+	a := make([]*ALine, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	if a[0].String != "Hello\n# World" {
+		t.Errorf("invalid string got=%q expected=%q", a[0].String, "Hello\n# World")
+	}
+	if a[0].Line != 2 || a[1].Line != 5 {
+		t.Errorf("invalid line numbers got=%d,%d expected=2,5", a[0].Line, a[1].Line)
+	}
+}
+
+func TestUnmarshalLongMultiLine(t *testing.T) {
+	lines := make([]string, 1000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d, \"\"%d\"\"", i, i)
+	}
+	text := strings.Join(lines, "\n")
+	data := "s,i,f,b\n\"" + text + "\",42,23.45,true\nHello,43,24.56,false"
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(data), &a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	if exp := strings.ReplaceAll(text, `""`, `"`); a[0].String != exp {
+		t.Errorf("invalid string got=%q expected=%q", a[0].String[:40], exp[:40])
+	}
+}
+
+func TestUnmarshalMaxRecordBytes(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvUnterminated))
+	dec := NewDecoder(r).MaxRecordBytes(64)
+	a := make([]*A, 0)
+	err := dec.Decode(&a)
+	if err == nil {
+		t.Errorf("expected error for oversized record")
+		return
+	}
+	if !strings.Contains(err.Error(), "record exceeds 64 bytes") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// without a limit the unterminated quote is reported at EOF
+	r = bytes.NewReader([]byte(CsvUnterminated))
+	if err = NewDecoder(r).Decode(&a); err == nil {
+		t.Errorf("expected error for unterminated quote")
+	}
+}
+
+type Scores struct {
+	Name   string   `csv:"name"`
+	Scores *[]int   `csv:"scores,split=;"`
+	Tags   []string `csv:"tags,split=|"`
+}
+
+const CsvSplit = `name,scores,tags
+Alice,1;2;3,a|b
+Bob,,`
+
+func TestUnmarshalSplit(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvSplit))
+	dec := NewDecoder(r)
+	s := make([]*Scores, 0)
+	if err := dec.Decode(&s); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(s), 2)
+		return
+	}
+	if s[0].Scores == nil || !reflect.DeepEqual(*s[0].Scores, []int{1, 2, 3}) {
+		t.Errorf("invalid scores got=%v expected=%v", s[0].Scores, []int{1, 2, 3})
+	}
+	if !reflect.DeepEqual(s[0].Tags, []string{"a", "b"}) {
+		t.Errorf("invalid tags got=%v expected=%v", s[0].Tags, []string{"a", "b"})
+	}
+	if (s[1].Scores != nil && len(*s[1].Scores) > 0) || len(s[1].Tags) > 0 {
+		t.Errorf("expected empty slices for empty fields got=%v %v", s[1].Scores, s[1].Tags)
+	}
+}
+
+const CsvNonNumericInt = `s,i,f,b
+Hello,forty-two,23.45,true`
+
+func TestUnmarshalLenientTypes(t *testing.T) {
+	a := make([]*A, 0)
+	if err := NewDecoder(bytes.NewReader([]byte(CsvNonNumericInt))).Decode(&a); err == nil {
+		t.Errorf("expected error for invalid int")
+	}
+
+	var fields []string
+	dec := NewDecoder(bytes.NewReader([]byte(CsvNonNumericInt))).LenientTypes(true)
+	dec.TypeErrorFunc(func(lineNo int, field, value string, err error) {
+		fields = append(fields, fmt.Sprintf("%d:%s:%s", lineNo, field, value))
+	})
+	a = a[:0]
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A{"Hello", true, 0, 23.45})
+	if len(fields) != 1 || fields[0] != "2:i:forty-two" {
+		t.Errorf("invalid type errors got=%v expected=%v", fields, []string{"2:i:forty-two"})
+	}
+}
+
+const CsvSections = `s,i,f,b
+Hello,42,23.45,true
+
+Hello World;43;24.56;false`
+
+func TestUnmarshalSections(t *testing.T) {
+	var breaks int
+	dec := NewDecoder(bytes.NewReader([]byte(CsvSections))).DetectSeparator(true)
+	dec.OnSectionBreak(func() { breaks++ })
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+	if breaks != 1 {
+		t.Errorf("invalid section breaks got=%d expected=%d", breaks, 1)
+	}
+}
+
+func TestUnmarshalDetectSampleLines(t *testing.T) {
+	const data = "s;b;i;f\nHello, World;true;42;23.45\nA,B,C,D;false;43;24.56"
+	dec := NewDecoder(bytes.NewReader([]byte(data))).DetectSeparator(true).DetectSampleLines(5)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"Hello, World", true, 42, 23.45})
+	CheckA(t, a[1], A{"A,B,C,D", false, 43, 24.56})
+
+	// consistent counts across sampled lines win over frequency on the first
+	if r, _ := detectSeparator([]string{"a,b,c;d", "1,2,3,4,5;6", "x;y"}); r != ';' {
+		t.Errorf("invalid separator got=%q expected=%q", r, ';')
+	}
+
+	// single line input
+	dec = NewDecoder(bytes.NewReader([]byte("s;b;i;f"))).DetectSeparator(true).DetectSampleLines(5)
+	a = a[:0]
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+	}
+	if exp := []string{"s", "b", "i", "f"}; !reflect.DeepEqual(dec.HeaderKeys(), exp) {
+		t.Errorf("invalid header got=%v expected=%v", dec.HeaderKeys(), exp)
+	}
+}
+
+func TestUnmarshalRows(t *testing.T) {
+	rows, err := NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).DecodeRows()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := [][]string{{"Hello", "42", "23.45", "true"}}; !reflect.DeepEqual(rows, exp) {
+		t.Errorf("invalid rows got=%v expected=%v", rows, exp)
+	}
+
+	rows, err = NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).Header(false).DecodeRows()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := [][]string{{"s", "i", "f", "b"}, {"Hello", "42", "23.45", "true"}}; !reflect.DeepEqual(rows, exp) {
+		t.Errorf("invalid rows got=%v expected=%v", rows, exp)
+	}
+}
+
+const CsvBareQuote = `s,i,f,b
+5" screen,42,23.45,true`
+
+func TestUnmarshalLazyQuotes(t *testing.T) {
+	a := make([]*A, 0)
+	err := NewDecoder(bytes.NewReader([]byte(CsvBareQuote))).Decode(&a)
+	if err == nil || !strings.Contains(err.Error(), "bare quote") {
+		t.Errorf("expected bare quote error, got=%v", err)
+	}
+	a = a[:0]
+	if err = NewDecoder(bytes.NewReader([]byte(CsvBareQuote))).LazyQuotes(true).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A{`5" screen`, true, 42, 23.45})
+}
+
+func TestUnmarshalQuoteTrailingData(t *testing.T) {
+	const data = "s,b,i,f\n\"abc\"def,true,42,23.45\n\"ghi\" ,false,43,1.5"
+	a := make([]*A, 0)
+	err := NewDecoder(bytes.NewReader([]byte(data))).Decode(&a)
+	if err == nil || !strings.Contains(err.Error(), "extraneous data after closing quote") {
+		t.Errorf("expected extraneous data error, got=%v", err)
+	}
+	a = a[:0]
+	if err = NewDecoder(bytes.NewReader([]byte(data))).LazyQuotes(true).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"abcdef", true, 42, 23.45})
+	CheckA(t, a[1], A{"ghi", false, 43, 1.5})
+}
+
+type Shipment struct {
+	Name    string `csv:"name"`
+	Ship    bool   `csv:"ship"`
+	Address string `csv:"shipping_address"`
+}
+
+const (
+	CsvShipping = `name,ship,shipping_address
+Alice,true,Main Street 1
+Bob,false,`
+	CsvShippingMissing = `name,ship,shipping_address
+Alice,true,`
+)
+
+func TestUnmarshalRequireIf(t *testing.T) {
+	shipping := func(record map[string]string) bool {
+		return record["ship"] == "true"
+	}
+	s := make([]*Shipment, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(CsvShipping))).RequireIf("shipping_address", shipping)
+	if err := dec.Decode(&s); err != nil {
+		t.Error(err)
+	}
+	if len(s) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(s), 2)
+	}
+
+	dec = NewDecoder(bytes.NewReader([]byte(CsvShippingMissing))).RequireIf("shipping_address", shipping)
+	err := dec.Decode(&s)
+	if err == nil {
+		t.Errorf("expected error for missing shipping address")
+		return
+	}
+	if exp := "csv: line 2 field 3 (shipping_address): required field is empty"; err.Error() != exp {
+		t.Errorf("invalid error got=%q expected=%q", err.Error(), exp)
+	}
+}
+
+type Color int
+
+const (
+	Red Color = iota
+	Green
+	Blue
+)
+
+func (c Color) String() string {
+	switch c {
+	case Red:
+		return "red"
+	case Green:
+		return "green"
+	case Blue:
+		return "blue"
+	}
+	return strconv.Itoa(int(c))
+}
+
+type Paint struct {
+	Name  string `csv:"name"`
+	Color Color  `csv:"color"`
+}
+
+func TestStringerEnum(t *testing.T) {
+	RegisterStringerEnum(Red, Green, Blue)
+	in := []Paint{{"sky", Blue}, {"grass", Green}, {"rose", Red}}
+	buf, err := Marshal(in)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	CheckOutput(t, buf, "name,color\nsky,blue\ngrass,green\nrose,red\n")
+	out := make([]Paint, 0)
+	if err := Unmarshal(buf, &out); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("invalid round-trip got=%v expected=%v", out, in)
+	}
+	if err := Unmarshal([]byte("name,color\nsun,yellow"), &out); err == nil {
+		t.Errorf("expected error for unknown enum value")
+	}
+}
+
+const CsvPaddedHeader = ` s , i , f , b
+  Hello  ,42,23.45,true`
+
+func TestUnmarshalTrimHeader(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte(CsvPaddedHeader))).TrimHeader(true).TrimFields(false)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A{"  Hello  ", true, 42, 23.45})
+}
+
+type AnyOnly struct {
+	Any map[string]string `csv:",any"`
+}
+
+func TestUnmarshalAnyOnly(t *testing.T) {
+	r := bytes.NewReader([]byte(CsvWithCRLF))
+	a := make([]*AnyOnly, 0)
+	if err := NewDecoder(r).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	exp := map[string]string{"s": "Hello World", "i": "43", "f": "24.56", "b": "false"}
+	if !reflect.DeepEqual(a[1].Any, exp) {
+		t.Errorf("invalid map got=%v expected=%v", a[1].Any, exp)
+	}
+
+	// without a header there is nothing to map columns to
+	r = bytes.NewReader([]byte(CsvWithoutHeader))
+	if err := NewDecoder(r).Header(false).Decode(&a); err == nil || !strings.Contains(err.Error(), "without mapped fields") {
+		t.Errorf("expected error for headerless map-only struct")
+	}
+}
+
+func TestUnmarshalInvalidConfig(t *testing.T) {
+	a := make([]*A, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).Comment(',')
+	err := dec.Decode(&a)
+	if err == nil || !strings.Contains(err.Error(), "separator and comment character must differ") {
+		t.Errorf("expected configuration error, got=%v", err)
+	}
+	dec = NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).Separator('"')
+	if err = dec.Decode(&a); err == nil {
+		t.Errorf("expected configuration error for quote separator")
+	}
+}
+
+type Host struct {
+	IP   net.IP     `csv:"ip"`
+	Addr netip.Addr `csv:"addr"`
+	URL  *url.URL   `csv:"url"`
+	Home url.URL    `csv:"home"`
+}
+
+func TestStdlibTypes(t *testing.T) {
+	u, _ := url.Parse("https://example.com/a?b=c")
+	h, _ := url.Parse("http://localhost:8080")
+	in := []Host{{net.ParseIP("10.0.0.1"), netip.MustParseAddr("::1"), u, *h}}
+	buf, err := Marshal(in)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	CheckOutput(t, buf, "ip,addr,url,home\n10.0.0.1,::1,https://example.com/a?b=c,http://localhost:8080\n")
+	out := make([]Host, 0)
+	if err := Unmarshal(buf, &out); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(out) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(out), 1)
+		return
+	}
+	if !out[0].IP.Equal(in[0].IP) || out[0].Addr != in[0].Addr {
+		t.Errorf("invalid addresses got=%v %v expected=%v %v", out[0].IP, out[0].Addr, in[0].IP, in[0].Addr)
+	}
+	if out[0].URL.String() != u.String() || out[0].Home.String() != h.String() {
+		t.Errorf("invalid urls got=%v %v expected=%v %v", out[0].URL, &out[0].Home, u, h)
+	}
+}
+
+func TestUnmarshalMultiDecoder(t *testing.T) {
+	dec := NewMultiDecoder(
+		bytes.NewReader([]byte(CsvWithHeader)),
+		bytes.NewReader([]byte(CsvWithoutLF)),
+	)
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 3 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 3)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A1)
+	CheckA(t, a[2], A2)
+
+	dec = NewMultiDecoder(
+		bytes.NewReader([]byte(CsvWithHeader)),
+		bytes.NewReader([]byte(CsvUnknownField)),
+	)
+	if err := dec.Decode(&a); err == nil {
+		t.Errorf("expected error for mismatched headers")
+	}
+}
+
+const CsvEndMarker = `s,i,f,b
+Hello,42,23.45,true
+Hello World,43,24.56,false
+__END__
+This is not "CSV", at all`
+
+func TestUnmarshalStopAt(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte(CsvEndMarker))).StopAt("__END__")
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+	if line, err := dec.ReadLine(); line != "" || err != nil {
+		t.Errorf("expected EOF after marker, got=%q %v", line, err)
+	}
+}
+
+type Signed struct {
+	Int   int64   `csv:"i"`
+	Uint  uint32  `csv:"u"`
+	Float float64 `csv:"f"`
+}
+
+func TestUnmarshalPlusSign(t *testing.T) {
+	s := make([]*Signed, 0)
+	if err := Unmarshal([]byte("i,u,f\n+42,+42,+42"), &s); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(s), 1)
+		return
+	}
+	if exp := (Signed{42, 42, 42}); *s[0] != exp {
+		t.Errorf("invalid values got=%v expected=%v", *s[0], exp)
+	}
+}
+
+type Nullable struct {
+	Name    string     `csv:"name"`
+	Created time.Time  `csv:"created"`
+	Deleted *time.Time `csv:"deleted"`
+	Count   int        `csv:"count"`
+}
+
+const CsvNullDates = `name,created,deleted,count
+a,2020-01-02,,NULL
+b,0000-00-00,2021-03-04,1
+c,,0000-00-00,2`
+
+func TestUnmarshalNullDates(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte(CsvNullDates)))
+	dec.NullValue("0000-00-00", "NULL").TimeFormat("2006-01-02")
+	n := make([]*Nullable, 0)
+	if err := dec.Decode(&n); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(n) != 3 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(n), 3)
+		return
+	}
+	if exp := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC); !n[0].Created.Equal(exp) {
+		t.Errorf("invalid time got=%v expected=%v", n[0].Created, exp)
+	}
+	if exp := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC); n[1].Deleted == nil || !n[1].Deleted.Equal(exp) {
+		t.Errorf("invalid time got=%v expected=%v", n[1].Deleted, exp)
+	}
+	for i, v := range n {
+		if i > 0 && !v.Created.IsZero() {
+			t.Errorf("record %d: expected zero time got=%v", i, v.Created)
+		}
+		if i != 1 && v.Deleted != nil && !v.Deleted.IsZero() {
+			t.Errorf("record %d: expected zero time got=%v", i, v.Deleted)
+		}
+	}
+	if n[0].Count != 0 {
+		t.Errorf("invalid count got=%d expected=%d", n[0].Count, 0)
+	}
+}
+
+func TestUnmarshalTrimStrings(t *testing.T) {
+	for _, trim := range []bool{true, false} {
+		dec := NewDecoder(bytes.NewReader([]byte("s,i\n  Hello  ,42\n\"  Hello World  \",43"))).TrimFields(trim)
+		a := make([]*A, 0)
+		if err := dec.Decode(&a); err != nil {
+			t.Error(err)
+			return
+		}
+		exp := []string{"Hello", "Hello World"}
+		if !trim {
+			exp = []string{"  Hello  ", "  Hello World  "}
+		}
+		for i, v := range a {
+			if v.String != exp[i] {
+				t.Errorf("trim=%t: invalid string got=%q expected=%q", trim, v.String, exp[i])
+			}
+		}
+	}
+}
+
+type Wide struct {
+	C0, C1, C2, C3, C4, C5, C6, C7, C8, C9 string
+}
+
+func BenchmarkUnmarshalWide(b *testing.B) {
+	var head, line bytes.Buffer
+	for i := 0; i < 10; i++ {
+		if i > 0 {
+			head.WriteByte(',')
+			line.WriteByte(',')
+		}
+		fmt.Fprintf(&head, "C%d", i)
+		fmt.Fprintf(&line, "  value %d  ", i)
+	}
+	dec := NewDecoder(bytes.NewReader(nil))
+	if _, err := dec.DecodeHeader(head.String()); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := &Wide{}
+		if err := dec.DecodeRecord(w, line.String()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type ARecord struct {
+	A
+	Record []string `csv:",record"`
+}
+
+func TestUnmarshalRecordField(t *testing.T) {
+	a := make([]*ARecord, 0)
+	if err := Unmarshal([]byte(CsvWithHeader), &a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, &a[0].A, A1)
+	if exp := []string{"Hello", "42", "23.45", "true"}; !reflect.DeepEqual(a[0].Record, exp) {
+		t.Errorf("invalid record got=%v expected=%v", a[0].Record, exp)
+	}
+
+	// record fields are not part of a derived header
+	buf, err := Marshal([]ARecord{{A: A1, Record: []string{"x"}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	CheckOutput(t, buf, "s,b,i,f\nHello,true,42,23.45\n")
+}
+
+type Address struct {
+	Street string `csv:"street"`
+	City   string `csv:"city"`
+}
+
+type Customer struct {
+	Name string `csv:"name"`
+	*Address
+}
+
+func TestUnmarshalOmitEmptyEmbedded(t *testing.T) {
+	const data = "name,street,city\nAlice,Main Street 1,Springfield\nBob,,"
+	for _, omit := range []bool{false, true} {
+		dec := NewDecoder(bytes.NewReader([]byte(data))).OmitEmptyEmbedded(omit)
+		c := make([]*Customer, 0)
+		if err := dec.Decode(&c); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(c) != 2 {
+			t.Errorf("invalid record count, got=%d expected=%d", len(c), 2)
+			return
+		}
+		if c[0].Address == nil || c[0].City != "Springfield" {
+			t.Errorf("omit=%t: invalid address got=%v", omit, c[0].Address)
+		}
+		if isNil := c[1].Address == nil; isNil != omit {
+			t.Errorf("omit=%t: invalid empty address got=%v", omit, c[1].Address)
+		}
+	}
+}
+
+func TestUnmarshalOmitEmptyEmbeddedMap(t *testing.T) {
+	m := make([]map[string]string, 0)
+	dec := NewDecoder(strings.NewReader("a,b\n1,2\n")).OmitEmptyEmbedded(true)
+	if err := dec.Decode(&m); err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := []map[string]string{{"a": "1", "b": "2"}}; !reflect.DeepEqual(m, exp) {
+		t.Errorf("invalid value got=%v expected=%v", m, exp)
+	}
+}
+
+func TestUnmarshalMatchGoNames(t *testing.T) {
+	const data = "String,Bool,Int,Float\nHello,true,42,23.45"
+	a := make([]*A, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(data))).SkipUnknown(false).MatchGoNames(true)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A1)
+}
+
+const CsvRepeatedHeader = `s,i,f,b
+Hello,42,23.45,true
+s,i,f,b
+Hello World,43,24.56,false`
+
+func TestUnmarshalRepeatedHeader(t *testing.T) {
+	a := make([]*A, 0)
+	if err := NewDecoder(bytes.NewReader([]byte(CsvRepeatedHeader))).Decode(&a); err == nil {
+		t.Errorf("expected error for repeated header")
+	}
+	a = a[:0]
+	dec := NewDecoder(bytes.NewReader([]byte(CsvRepeatedHeader))).RepeatedHeader(true)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+}
+
+type Pixel struct {
+	Name string `csv:"name"`
+	RGB  [3]int `csv:"rgb,split=;"`
+}
+
+func TestUnmarshalSplitArray(t *testing.T) {
+	p := make([]Pixel, 0)
+	if err := Unmarshal([]byte("name,rgb\npurple,255;0;128"), &p); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(p) != 1 || p[0].RGB != [3]int{255, 0, 128} {
+		t.Errorf("invalid array got=%v expected=%v", p, [3]int{255, 0, 128})
+	}
+	buf, err := Marshal(p)
+	if err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, buf, "name,rgb\npurple,255;0;128\n")
+
+	err = Unmarshal([]byte("name,rgb\npurple,255;0"), &p)
+	if err == nil || !strings.Contains(err.Error(), "expected 3 elements, got 2") {
+		t.Errorf("expected element count error, got=%v", err)
+	}
+}
+
+func TestUnmarshalPreprocessLine(t *testing.T) {
+	const data = "s,i,f,b;\nHello,42,23.45,true;\nHello World,43,24.56,false;"
+	dec := NewDecoder(bytes.NewReader([]byte(data))).PreprocessLine(func(line string) string {
+		return strings.TrimSuffix(line, ";")
+	})
+	a := make([]*A, 0)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+}
+
+func TestUnmarshalMergeNumericSplits(t *testing.T) {
+	const data = "s,i,f,b\nHello,42,1,234.5,true\nHello World,1,000,24.56,false"
+	a := make([]*A, 0)
+	if err := NewDecoder(bytes.NewReader([]byte(data))).Decode(&a); err == nil {
+		t.Errorf("expected field count error")
+	}
+	a = a[:0]
+	if err := NewDecoder(bytes.NewReader([]byte(data))).MergeNumericSplits(true).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"Hello", true, 42, 1234.5})
+	CheckA(t, a[1], A{"Hello World", false, 1000, 24.56})
+}
+
+func TestUnmarshalUnderscores(t *testing.T) {
+	const data = "i,u,f\n1_000,+2_000,1_000.5"
+	s := make([]*Signed, 0)
+	if err := Unmarshal([]byte(data), &s); err == nil {
+		t.Errorf("expected error for underscores")
+	}
+	s = s[:0]
+	if err := NewDecoder(bytes.NewReader([]byte(data))).AllowUnderscores(true).Decode(&s); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(s) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(s), 1)
+		return
+	}
+	if exp := (Signed{1000, 2000, 1000.5}); *s[0] != exp {
+		t.Errorf("invalid values got=%v expected=%v", *s[0], exp)
+	}
+}
+
+func TestDecodeLine(t *testing.T) {
+	a, err := DecodeLine[A]([]string{"s", "i", "b", "f"}, "Hello,42,true,1.5")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := (A{"Hello", true, 42, 1.5}); a != exp {
+		t.Errorf("invalid value got=%v expected=%v", a, exp)
+	}
+
+	// header derived from type with options
+	a, err = DecodeLine[A](nil, "Hello;true;42;1.5", func(d *Decoder) { d.Separator(';') })
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := (A{"Hello", true, 42, 1.5}); a != exp {
+		t.Errorf("invalid value got=%v expected=%v", a, exp)
+	}
+
+	if _, err = DecodeLine[A]([]string{"s", "i"}, "Hello,x"); err == nil {
+		t.Errorf("expected error for invalid int")
+	}
+}
+
+func TestUnmarshalSkipColumns(t *testing.T) {
+	const data = "Hello,ignored,true,42,1.5,x\nWorld,ignored,false,7,0.5,y"
+	a := make([]*A, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(data))).Header(false).SkipColumns(1, 5)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	if exp := (A{"Hello", true, 42, 1.5}); *a[0] != exp {
+		t.Errorf("invalid value got=%v expected=%v", *a[0], exp)
+	}
+	if exp := (A{"World", false, 7, 0.5}); *a[1] != exp {
+		t.Errorf("invalid value got=%v expected=%v", *a[1], exp)
+	}
+	if n := len(dec.UnknownColumns()); n != 0 {
+		t.Errorf("expected no unknown columns, got %d", n)
+	}
+}
+
+func TestUnmarshalErrorUnwrap(t *testing.T) {
+	const data = "s,i\nHello,x42"
+	a := make([]*A, 0)
+	err := Unmarshal([]byte(data), &a)
+	if err == nil {
+		t.Errorf("expected error for invalid int")
+		return
+	}
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Errorf("expected DecodeError, got %T", err)
+		return
+	}
+	if derr.Line() != 2 || derr.Field() != 2 || derr.FieldName() != "i" {
+		t.Errorf("invalid error position line=%d field=%d name=%s", derr.Line(), derr.Field(), derr.FieldName())
+	}
+	var nerr *strconv.NumError
+	if !errors.As(err, &nerr) {
+		t.Errorf("expected strconv.NumError, got %v", err)
+		return
+	}
+	if nerr.Num != "x42" || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("invalid NumError %v", nerr)
+	}
+}
+
+func TestUnmarshalEach(t *testing.T) {
+	const data = "s,i\nA,1\nB,2\nC,39"
+	var (
+		v   A
+		sum int64
+	)
+	dec := NewDecoder(bytes.NewReader([]byte(data)))
+	if err := dec.Each(&v, func() error {
+		sum += v.Int
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+	if sum != 42 {
+		t.Errorf("invalid sum got=%d expected=%d", sum, 42)
+	}
+
+	// errors returned by fn abort decoding
+	errStop := fmt.Errorf("stop")
+	var n int
+	dec = NewDecoder(bytes.NewReader([]byte(data)))
+	if err := dec.Each(&v, func() error {
+		n++
+		return errStop
+	}); err != errStop {
+		t.Errorf("expected stop error, got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("invalid call count got=%d expected=%d", n, 1)
+	}
+
+	if err := NewDecoder(bytes.NewReader([]byte(data))).Each(v, func() error { return nil }); err == nil {
+		t.Errorf("expected error for non-pointer")
+	}
+}
+
+func TestUnmarshalDuplicateKeys(t *testing.T) {
+	const data = "s,note,b,note\nHello,first,true,second"
+	b := make([]*B, 0)
+	if err := Unmarshal([]byte(data), &b); err != nil {
+		t.Error(err)
+		return
+	}
+	if v := b[0].Any["note"]; v != "second" {
+		t.Errorf("invalid overwritten value got=%s expected=%s", v, "second")
+	}
+
+	b = b[:0]
+	dec := NewDecoder(bytes.NewReader([]byte(data))).DuplicateKeys(SuffixDuplicates)
+	if err := dec.Decode(&b); err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := map[string]string{"note": "first", "note_2": "second"}; !reflect.DeepEqual(b[0].Any, exp) {
+		t.Errorf("invalid any map got=%v expected=%v", b[0].Any, exp)
+	}
+
+	b = b[:0]
+	dec = NewDecoder(bytes.NewReader([]byte(data))).DuplicateKeys(RejectDuplicates)
+	if err := dec.Decode(&b); err == nil {
+		t.Errorf("expected error for duplicate field")
+	}
+}
+
+func TestUnmarshalLimitBytes(t *testing.T) {
+	const frame = "s,i\nHello,42\nWorld,43\n"
+	r := strings.NewReader(frame + "next,frame\n")
+	a := make([]*A, 0)
+	if err := NewDecoder(r).LimitBytes(int64(len(frame))).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	if a[1].String != "World" || a[1].Int != 43 {
+		t.Errorf("invalid value %v", *a[1])
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(rest) != "next,frame\n" {
+		t.Errorf("invalid remaining input %q", string(rest))
+	}
+}
+
+func TestUnmarshalBackslashEscapes(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{`a\tb`, "a\tb"},
+		{`a\nb`, "a\nb"},
+		{`a\rb`, "a\rb"},
+		{`a\\b`, `a\b`},
+		{`a\\tb`, `a\tb`},
+		{`\"a\"`, `"a"`},
+		{`"a\tb"`, "a\tb"},
+		{"a\\\tb", "a\tb"},
+		{` a `, ` a `},
+	}
+	for _, test := range tests {
+		a := make([]*A, 0)
+		dec := NewTSVDecoder(strings.NewReader("s\ti\n" + test.in + "\t42"))
+		if err := dec.Decode(&a); err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if len(a) != 1 {
+			t.Errorf("%q: invalid record count, got=%d expected=%d", test.in, len(a), 1)
+			continue
+		}
+		if a[0].String != test.out || a[0].Int != 42 {
+			t.Errorf("%q: invalid value got=%q expected=%q", test.in, a[0].String, test.out)
+		}
+	}
+}
+
+type JSONTagged struct {
+	Name    string  `json:"name"`
+	Count   int     `json:"count,omitempty"`
+	Price   float64 `json:"price" csv:"cost"`
+	Ignored string  `json:"-"`
+}
+
+func TestUnmarshalTagName(t *testing.T) {
+	const data = "name,count,price,Ignored\nx,3,1.5,y"
+	v := make([]JSONTagged, 0)
+	if err := NewDecoder(strings.NewReader(data)).TagName("json").Decode(&v); err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := []JSONTagged{{"x", 3, 1.5, ""}}; !reflect.DeepEqual(v, exp) {
+		t.Errorf("invalid value got=%v expected=%v", v, exp)
+	}
+
+	// csv tags are used by default
+	v = v[:0]
+	if err := NewDecoder(strings.NewReader(data)).SkipUnknown(false).Decode(&v); err == nil {
+		t.Errorf("expected error for unknown fields with csv tags")
+	}
+}
+
+func TestTypeInfoTagNames(t *testing.T) {
+	typ := reflect.TypeOf(JSONTagged{})
+	names := func(tag string) []string {
+		tinfo, err := getTypeInfo(typ, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n []string
+		for _, finfo := range tinfo.fields {
+			n = append(n, finfo.name)
+		}
+		return n
+	}
+	// fetch twice to use cached type info
+	for i := 0; i < 2; i++ {
+		if exp := []string{"Name", "Count", "cost", "Ignored"}; !reflect.DeepEqual(names(tagName), exp) {
+			t.Errorf("invalid csv field names got=%v expected=%v", names(tagName), exp)
+		}
+		if exp := []string{"name", "count", "price"}; !reflect.DeepEqual(names("json"), exp) {
+			t.Errorf("invalid json field names got=%v expected=%v", names("json"), exp)
+		}
+	}
+}
+
+func TestClearTypeCache(t *testing.T) {
+	if _, err := getTypeInfo(reflect.TypeOf(A{}), tagName); err != nil {
+		t.Fatal(err)
+	}
+	ClearTypeCache()
+	tinfoLock.RLock()
+	n := len(tinfoMap)
+	tinfoLock.RUnlock()
+	if n != 0 {
+		t.Errorf("invalid cache size after clear got=%d expected=%d", n, 0)
+	}
+
+	// type info is recreated on demand
+	a := make([]*A, 0)
+	if err := Unmarshal([]byte(CsvWithHeader), &a); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalDecodeAs(t *testing.T) {
+	v := make([]interface{}, 0)
+	dec := NewDecoder(bytes.NewReader([]byte(CsvWithHeader)))
+	if err := dec.DecodeAs(&v, reflect.TypeOf(A{})); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(v) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(v), 1)
+		return
+	}
+	a, ok := v[0].(A)
+	if !ok {
+		t.Errorf("invalid element type %T", v[0])
+		return
+	}
+	CheckA(t, &a, A1)
+
+	var s []fmt.Stringer
+	if err := NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).DecodeAs(&s, reflect.TypeOf(A{})); err == nil {
+		t.Errorf("expected error for non-assignable type")
+	}
+}
+
+func TestUnmarshalTrimCutset(t *testing.T) {
+	const data = "**s**,i*,*f,b\n***Hello***,**42,23.45**,*true*"
+	a := make([]*A, 0)
+	if err := NewDecoder(strings.NewReader(data)).TrimCutset("*").Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 1 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 1)
+		return
+	}
+	CheckA(t, a[0], A1)
+
+	// disabled trimming keeps the cutset
+	a = a[:0]
+	if err := NewDecoder(strings.NewReader("s\n*Hello*")).TrimCutset("*").TrimFields(false).Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if a[0].String != "*Hello*" {
+		t.Errorf("invalid value got=%s expected=%s", a[0].String, "*Hello*")
+	}
+
+	// quoted fields may be padded with the cutset
+	for _, data := range []string{"s,i\n**\"Hello, World\"**,42", "s,i\n\t\"Hello, World\"\t,42"} {
+		a = a[:0]
+		if err := NewDecoder(strings.NewReader(data)).TrimCutset("*\t").Decode(&a); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(a) != 1 || a[0].String != "Hello, World" || a[0].Int != 42 {
+			t.Errorf("invalid record got=%v", a)
+		}
+	}
+}
+
+func TestUnmarshalDiscriminatorColumn(t *testing.T) {
+	const data = "H,export,2026-10-15\nD,Hello,true,42,23.45\nD,Hello World,false,43,24.56\nT,2"
+	var kinds []string
+	a := make([]*A, 0)
+	dec := NewDecoder(strings.NewReader(data)).Header(false).SkipColumns(0).DiscriminatorColumn(0, "D")
+	dec.OnOtherRecord(func(kind string, fields []string) error {
+		kinds = append(kinds, kind)
+		return nil
+	})
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A1)
+	CheckA(t, a[1], A2)
+	if exp := []string{"H", "T"}; !reflect.DeepEqual(kinds, exp) {
+		t.Errorf("invalid other records got=%v expected=%v", kinds, exp)
+	}
+}
+
+type Buyer struct {
+	Name string `csv:"name"`
+}
+
+type Company struct {
+	Name string `csv:"name"`
+}
+
+type Contract struct {
+	Buyer   `csv:",prefix"`
+	Company `csv:"company,prefix"`
+	Id      int `csv:"id"`
+}
+
+func TestUnmarshalEmbeddedPrefix(t *testing.T) {
+	const data = "id,Buyer.name,company.name\n1,Alice,ACME"
+	v := make([]Contract, 0)
+	if err := NewDecoder(strings.NewReader(data)).SkipUnknown(false).Decode(&v); err != nil {
+		t.Error(err)
+		return
+	}
+	exp := []Contract{{Buyer{"Alice"}, Company{"ACME"}, 1}}
+	if !reflect.DeepEqual(v, exp) {
+		t.Errorf("invalid value got=%v expected=%v", v, exp)
+	}
+
+	// encoded header uses the same names
+	buf, err := Marshal(v)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if s := string(buf); s != "Buyer.name,company.name,id\nAlice,ACME,1\n" {
+		t.Errorf("invalid output %q", s)
+	}
+}
+
+func TestUnmarshalHeaderTrailingComma(t *testing.T) {
+	const data = "s,b,i,\nHello,true,42,\nHello World,false,43"
+	dec := NewDecoder(strings.NewReader(data)).HeaderTrailingComma(KeepTrailingComma)
+	a := make([]*A, 0)
+	err := dec.Decode(&a)
+	if err == nil {
+		t.Errorf("expected field count error when keeping the empty header")
+	}
+	if exp := []string{"s", "b", "i", ""}; !reflect.DeepEqual(dec.HeaderKeys(), exp) {
+		t.Errorf("invalid header got=%v expected=%v", dec.HeaderKeys(), exp)
+	}
+
+	a = a[:0]
+	dec = NewDecoder(strings.NewReader(data)).HeaderTrailingComma(DropTrailingComma)
+	if err := dec.Decode(&a); err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := []string{"s", "b", "i"}; !reflect.DeepEqual(dec.HeaderKeys(), exp) {
+		t.Errorf("invalid header got=%v expected=%v", dec.HeaderKeys(), exp)
+	}
+	if len(a) != 2 {
+		t.Errorf("invalid record count, got=%d expected=%d", len(a), 2)
+		return
+	}
+	CheckA(t, a[0], A{"Hello", true, 42, 0})
+	CheckA(t, a[1], A{"Hello World", false, 43, 0})
+
+	a = a[:0]
+	dec = NewDecoder(strings.NewReader(data)).HeaderTrailingComma(RejectTrailingComma)
+	if err := dec.Decode(&a); err == nil || !strings.Contains(err.Error(), "trailing separator") {
+		t.Errorf("expected trailing separator error, got %v", err)
+	}
+}
+
+func TestDecodeTable(t *testing.T) {
+	header, rows, err := NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).DecodeTable()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if exp := []string{"s", "i", "f", "b"}; !reflect.DeepEqual(header, exp) {
+		t.Errorf("invalid header got=%v expected=%v", header, exp)
+	}
+	exp := []map[string]string{{"s": "Hello", "i": "42", "f": "23.45", "b": "true"}}
+	if !reflect.DeepEqual(rows, exp) {
+		t.Errorf("invalid rows got=%v expected=%v", rows, exp)
+	}
+
+	if _, _, err = NewDecoder(bytes.NewReader([]byte(CsvWithHeader))).Header(false).DecodeTable(); err == nil {
+		t.Errorf("expected error without header")
+	}
+}
+
+type Schedule struct {
+	At time.Time `csv:"at"`
+}
+
+func TestUnmarshalTimeFormats(t *testing.T) {
+	const data = "at\n2023-01-02\n15:04:05\n2023-01-02T15:04:05Z"
+	layouts := []string{"2006-01-02", "15:04:05", time.RFC3339}
+	v := make([]Schedule, 0)
+	if err := NewDecoder(strings.NewReader(data)).TimeFormats(layouts...).Decode(&v); err != nil {
+		t.Error(err)
+		return
+	}
+	exp := []Schedule{
+		{time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(0, 1, 1, 15, 4, 5, 0, time.UTC)},
+		{time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(v, exp) {
+		t.Errorf("invalid value got=%v expected=%v", v, exp)
+	}
+
+	// a value matching no layout fails with the first layout's error
+	v = v[:0]
+	err := NewDecoder(strings.NewReader("at\nnoon")).TimeFormats(layouts...).Decode(&v)
+	if err == nil || !strings.Contains(err.Error(), "2006-01-02") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+
+	// the first layout is used for encoding
+	buf := new(bytes.Buffer)
+	if err := NewEncoder(buf).TimeFormats(layouts...).Encode(exp[:1]); err != nil {
+		t.Error(err)
+	}
+	CheckOutput(t, buf.Bytes(), "at\n2023-01-02\n")
+}
+
+type KV struct {
+	A int     `csv:"a"`
+	B float64 `csv:"b"`
+}
+
+type Settings struct {
+	Name string `csv:"name"`
+	KV   KV     `csv:"kv,kv=;"`
+	Ptr  *KV    `csv:"ptr,kv=|="`
+}
+
+func TestUnmarshalKeyValues(t *testing.T) {
+	const data = "name,kv,ptr\nx,a:1;b:2.5,b=3|a=4\ny,,"
+	v := make([]Settings, 0)
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Error(err)
+		return
+	}
+	exp := []Settings{{"x", KV{1, 2.5}, &KV{4, 3}}, {"y", KV{}, &KV{}}}
+	if !reflect.DeepEqual(v, exp) {
+		t.Errorf("invalid value got=%v expected=%v", v, exp)
+	}
+
+	v = v[:0]
+	if err := Unmarshal([]byte("name,kv,ptr\nx,a=1,"), &v); err == nil {
+		t.Errorf("expected error for invalid pair")
+	}
+	v = v[:0]
+	if err := NewDecoder(strings.NewReader("name,kv,ptr\nx,c:1,")).SkipUnknown(false).Decode(&v); err == nil {
+		t.Errorf("expected error for unknown key")
+	}
+}
+
+func TestUnmarshalRequireData(t *testing.T) {
+	a := make([]*A, 0)
+	if err := NewDecoder(strings.NewReader("")).Decode(&a); err != nil {
+		t.Errorf("unexpected error without RequireData: %v", err)
+	}
+	err := NewDecoder(strings.NewReader("")).RequireData(true).Decode(&a)
+	if err == nil || !strings.Contains(err.Error(), "empty input") {
+		t.Errorf("expected empty input error, got %v", err)
+	}
+	err = NewDecoder(strings.NewReader("s,i,f,b\n")).RequireData(true).Decode(&a)
+	if err == nil || !strings.Contains(err.Error(), "no records") {
+		t.Errorf("expected no records error, got %v", err)
+	}
+	if err = NewDecoder(strings.NewReader(CsvWithHeader)).RequireData(true).Decode(&a); err != nil {
+		t.Error(err)
+	}
+}